@@ -0,0 +1,207 @@
+package np
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"go.rbn.im/neinp/message"
+)
+
+// Authenticator authenticates users before they can attach.
+type Authenticator interface {
+	// Auth starts the authentication of uname, which wants to attach to
+	// aname.
+	Auth(uname, aname string) (AuthConv, error)
+}
+
+// AuthConv is an authentication conversation.
+//
+// The client reads and writes the conversation through an auth fid, offsets
+// are ignored. If the conversation has a Close method, it is called when the
+// auth fid is clunked.
+type AuthConv interface {
+	io.ReadWriter
+
+	// Authenticated returns true once the conversation has finished
+	// successfully.
+	Authenticated() bool
+}
+
+// authFile is the Node an auth fid points to.
+type authFile struct {
+	conv AuthConv
+	path uint64
+
+	// the tree the conversation authenticates for
+	aname string
+}
+
+var (
+	_ io.ReaderAt = &authFile{}
+	_ io.WriterAt = &authFile{}
+	_ io.Closer   = &authFile{}
+)
+
+func (f *authFile) Stat() (Stat, error) {
+	return Stat{
+		Qid:  Qid{Type: QTAuth, Path: f.path},
+		Mode: ModeAuth | 0o600,
+	}, nil
+}
+
+func (f *authFile) ReadAt(p []byte, off int64) (int, error) {
+	return f.conv.Read(p) //nolint:wrapcheck
+}
+
+func (f *authFile) WriteAt(p []byte, off int64) (int, error) {
+	return f.conv.Write(p) //nolint:wrapcheck
+}
+
+func (f *authFile) Close() error {
+	if c, ok := f.conv.(io.Closer); ok {
+		return c.Close() //nolint:wrapcheck
+	}
+	return nil
+}
+
+func (s *server) auth(m message.TAuth) (*message.RAuth, error) {
+	if s.authn == nil {
+		return nil, ErrNoAuth
+	}
+
+	if s.fids.Get(m.Afid) != nil {
+		return nil, ErrDupFid
+	}
+
+	conv, err := s.authn.Auth(m.Uname, m.Aname)
+	if err != nil {
+		return nil, fmt.Errorf("auth: %w", err)
+	}
+
+	af := &authFile{
+		conv:  conv,
+		path:  atomic.AddUint64(&s.authPaths, 1),
+		aname: m.Aname,
+	}
+
+	fd := newfd(nil, m.Uname, m.Aname)
+	fd.open = af
+	fd.mode = ORdwr
+	fd.auth = af
+	s.fids.Set(m.Afid, fd)
+
+	return &message.RAuth{Aqid: Qid{Type: QTAuth, Path: af.path}}, nil
+}
+
+// checkAuth returns ErrAuth if afid has not authenticated uname to attach to
+// aname.
+func (s *server) checkAuth(afid uint32, uname, aname string) error {
+	if s.authn == nil {
+		return nil
+	}
+
+	fd := s.fids.Get(afid)
+	if fd == nil || fd.auth == nil || fd.uname != uname || fd.auth.aname != aname {
+		return ErrAuth
+	}
+
+	if !fd.auth.conv.Authenticated() {
+		return ErrAuth
+	}
+
+	return nil
+}
+
+// SharedSecret returns an Authenticator that authenticates users that know a
+// secret shared with the server.
+//
+// secret returns the secret of uname, or false if uname is not known. The
+// client reads a hex encoded challenge from the auth fid and writes back the
+// response computed by SharedSecretResponse.
+func SharedSecret(secret func(uname string) ([]byte, bool)) Authenticator { //nolint:ireturn
+	return sharedSecret(secret)
+}
+
+type sharedSecret func(uname string) ([]byte, bool)
+
+func (fn sharedSecret) Auth(uname, aname string) (AuthConv, error) { //nolint:ireturn
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, fmt.Errorf("challenge: %w", err)
+	}
+
+	// unknown users get a challenge too, they just can't answer it
+	key, ok := fn(uname)
+	c := &secretConv{
+		known:     ok,
+		key:       key,
+		challenge: []byte(hex.EncodeToString(nonce[:])),
+	}
+	return c, nil
+}
+
+// secretConv is a SharedSecret conversation.
+type secretConv struct {
+	mu        sync.Mutex
+	known     bool
+	key       []byte
+	challenge []byte
+	off       int
+	done      bool
+	failed    bool
+}
+
+// Read reads the challenge.
+func (c *secretConv) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.off == len(c.challenge) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, c.challenge[c.off:])
+	c.off += n
+	return n, nil
+}
+
+// Write checks the response to the challenge.
+//
+// There is only one try, a wrong response ends the conversation.
+func (c *secretConv) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done || c.failed {
+		return 0, ErrBotch
+	}
+
+	want := SharedSecretResponse(c.key, c.challenge)
+	if !c.known || !hmac.Equal(p, want) {
+		c.failed = true
+		return 0, ErrAuth
+	}
+
+	c.done = true
+	return len(p), nil
+}
+
+func (c *secretConv) Authenticated() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done
+}
+
+// SharedSecretResponse returns the response to a SharedSecret challenge: the
+// hex encoded HMAC-SHA256 of the challenge, keyed with the secret.
+func SharedSecretResponse(secret, challenge []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(challenge)
+	return []byte(hex.EncodeToString(mac.Sum(nil)))
+}
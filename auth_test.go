@@ -0,0 +1,78 @@
+package np_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/noonien/np"
+	"github.com/noonien/np/client"
+	"github.com/stretchr/testify/require"
+)
+
+// authenticate answers the challenge of a SharedSecret conversation for
+// uname and aname with secret, and returns the auth fid.
+func authenticate(t *testing.T, c *client.Client, uname, aname, secret string) (*client.Fid, error) {
+	t.Helper()
+
+	ctx := context.Background()
+	afid, err := c.Auth(ctx, uname, aname)
+	require.Nil(t, err)
+
+	buf := make([]byte, 64)
+	n, err := afid.Read(ctx, buf, 0)
+	require.Nil(t, err)
+
+	_, err = afid.Write(ctx, np.SharedSecretResponse([]byte(secret), buf[:n]), 0)
+	return afid, err
+}
+
+func TestSharedSecret(t *testing.T) {
+	t.Parallel()
+
+	secrets := map[string]string{"glenda": "rabbit"}
+	srv := np.NewServer(np.ReadFunc("f", nil), np.Auth(np.SharedSecret(func(uname string) ([]byte, bool) {
+		s, ok := secrets[uname]
+		return []byte(s), ok
+	})))
+	c := dial(t, srv)
+	ctx := context.Background()
+
+	// attaching needs an authenticated fid
+	_, err := c.Attach(ctx, nil, "glenda", "")
+	require.ErrorIs(t, err, np.ErrAuth)
+
+	afid, err := authenticate(t, c, "glenda", "", "rabbit")
+	require.Nil(t, err)
+
+	// the fid authenticates the uname and aname it was created for only
+	_, err = c.Attach(ctx, afid, "bootes", "")
+	require.ErrorIs(t, err, np.ErrAuth)
+	_, err = c.Attach(ctx, afid, "glenda", "other")
+	require.ErrorIs(t, err, np.ErrAuth)
+
+	root, err := c.Attach(ctx, afid, "glenda", "")
+	require.Nil(t, err)
+	require.Nil(t, root.Clunk(ctx))
+	require.Nil(t, afid.Clunk(ctx))
+
+	// wrong secrets and unknown users fail, there is only one try
+	afid, err = authenticate(t, c, "glenda", "", "hare")
+	require.ErrorIs(t, err, np.ErrAuth)
+	_, err = afid.Write(ctx, np.SharedSecretResponse([]byte("rabbit"), nil), 0)
+	require.ErrorIs(t, err, np.ErrBotch)
+	_, err = c.Attach(ctx, afid, "glenda", "")
+	require.ErrorIs(t, err, np.ErrAuth)
+
+	_, err = authenticate(t, c, "bootes", "", "")
+	require.ErrorIs(t, err, np.ErrAuth)
+}
+
+func TestSharedSecretResponse(t *testing.T) {
+	t.Parallel()
+
+	a := np.SharedSecretResponse([]byte("rabbit"), []byte("challenge"))
+	require.Len(t, a, 64)
+	require.Equal(t, a, np.SharedSecretResponse([]byte("rabbit"), []byte("challenge")))
+	require.NotEqual(t, a, np.SharedSecretResponse([]byte("hare"), []byte("challenge")))
+	require.NotEqual(t, a, np.SharedSecretResponse([]byte("rabbit"), []byte("other")))
+}
@@ -0,0 +1,344 @@
+// Package client implements a 9P2000 client.
+//
+// Requests can be made concurrently, every request gets its own tag and
+// responses are matched to their requests as they arrive. Canceling the
+// context of a request flushes it.
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"go.rbn.im/neinp/message"
+)
+
+// DefaultMsize is the msize proposed by clients that don't use the Msize
+// option.
+const DefaultMsize = 0x2000
+
+const (
+	noTag = ^uint16(0)
+	noFid = ^uint32(0)
+
+	// ioHeaderSize is the size of the header of Twrite and Rread messages,
+	// see intro(5).
+	ioHeaderSize = 24
+
+	// maxWelem is the maximum number of names in a Twalk.
+	maxWelem = 16
+)
+
+var (
+	// ErrClosed is returned by requests made on a closed Client.
+	ErrClosed = errors.New("client closed")
+
+	// ErrVersion is returned by NewClient if the server doesn't speak
+	// 9P2000.
+	ErrVersion = errors.New("unsupported version")
+
+	// ErrUnexpected is returned if the server responds with the wrong
+	// message type.
+	ErrUnexpected = errors.New("unexpected response")
+
+	errNoTags = errors.New("out of tags")
+)
+
+type Option func(*Client)
+
+// Msize sets the maximum message size proposed to the server.
+func Msize(msize uint32) Option {
+	return func(c *Client) {
+		c.msize = msize
+	}
+}
+
+// Client is a connection to a 9P2000 server.
+type Client struct {
+	rwc   io.ReadWriteCloser
+	msize uint32
+
+	wmu sync.Mutex
+
+	mu      sync.Mutex
+	tags    map[uint16]chan message.Content
+	nextTag uint16
+	nextFid uint32
+	err     error
+	done    chan struct{}
+}
+
+// Dial connects to the 9P2000 server at address, see net.Dial.
+func Dial(ctx context.Context, network, address string, opts ...Option) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+
+	c, err := NewClient(ctx, conn, opts...)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewClient negotiates the version and msize with the server on the other
+// end of rwc.
+//
+// The Client owns rwc, it is closed by Close.
+func NewClient(ctx context.Context, rwc io.ReadWriteCloser, opts ...Option) (*Client, error) {
+	c := &Client{
+		rwc:   rwc,
+		msize: DefaultMsize,
+		tags:  make(map[uint16]chan message.Content),
+		done:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	go c.rcv(c.msize)
+
+	if err := c.version(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) version(ctx context.Context) error {
+	ch := make(chan message.Content, 1)
+	c.mu.Lock()
+	c.tags[noTag] = ch
+	c.mu.Unlock()
+	defer c.freeTag(noTag)
+
+	req := &message.TVersion{Msize: c.msize, Version: "9P2000"}
+	if err := c.send(noTag, req); err != nil {
+		return err
+	}
+
+	// Tversion can't be flushed
+	var res message.Content
+	select {
+	case res = <-ch:
+	case <-ctx.Done():
+		return ctx.Err() //nolint:wrapcheck
+	case <-c.done:
+		return c.err
+	}
+
+	rv, ok := res.(*message.RVersion)
+	if !ok {
+		if re, ok := res.(*message.RError); ok {
+			return &Error{Ename: re.Ename}
+		}
+		return fmt.Errorf("%w: %T", ErrUnexpected, res)
+	}
+
+	base, _, _ := strings.Cut(rv.Version, ".")
+	if base != "9P2000" {
+		return fmt.Errorf("%w: %q", ErrVersion, rv.Version)
+	}
+	if rv.Msize > c.msize || rv.Msize <= ioHeaderSize {
+		return fmt.Errorf("%w: msize %d", ErrVersion, rv.Msize)
+	}
+	c.msize = rv.Msize
+
+	return nil
+}
+
+// Msize returns the negotiated maximum message size.
+func (c *Client) Msize() uint32 {
+	return c.msize
+}
+
+// Close closes the connection, outstanding requests return ErrClosed.
+func (c *Client) Close() error {
+	c.fail(ErrClosed)
+	return c.rwc.Close() //nolint:wrapcheck
+}
+
+// fail ends the connection with err, if it hasn't already ended.
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+}
+
+// rcv reads responses and hands them to the requests waiting for them.
+func (c *Client) rcv(msize uint32) {
+	for {
+		lr := io.LimitReader(c.rwc, int64(msize))
+
+		var res message.Message
+		if _, err := res.Decode(lr); err != nil {
+			c.fail(fmt.Errorf("9p decode: %w", err))
+			c.rwc.Close()
+			return
+		}
+
+		c.mu.Lock()
+		ch := c.tags[res.Tag]
+		c.mu.Unlock()
+
+		// responses to unknown tags are dropped, every tag gets one
+		// response so ch never blocks
+		if ch != nil {
+			ch <- res.Content
+		}
+	}
+}
+
+func (c *Client) send(tag uint16, req message.Content) error {
+	var b bytes.Buffer
+	m := message.Message{Tag: tag, Content: req}
+	if _, err := m.Encode(&b); err != nil {
+		return fmt.Errorf("9p encode: %w", err)
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if _, err := c.rwc.Write(b.Bytes()); err != nil {
+		c.fail(fmt.Errorf("write: %w", err))
+		return c.err
+	}
+	return nil
+}
+
+func (c *Client) newTag() (uint16, chan message.Content, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return 0, nil, c.err
+	}
+
+	for i := 0; i < int(noTag); i++ {
+		tag := c.nextTag
+		c.nextTag++
+		if c.nextTag == noTag {
+			c.nextTag = 0
+		}
+
+		if _, ok := c.tags[tag]; !ok {
+			ch := make(chan message.Content, 1)
+			c.tags[tag] = ch
+			return tag, ch, nil
+		}
+	}
+
+	return 0, nil, errNoTags
+}
+
+func (c *Client) freeTag(tag uint16) {
+	c.mu.Lock()
+	delete(c.tags, tag)
+	c.mu.Unlock()
+}
+
+// newFid returns an unused fid number.
+//
+// Fid numbers are never reused, a fid whose clunk was flushed may still
+// exist on the server.
+func (c *Client) newFid() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fid := c.nextFid
+	c.nextFid++
+	if c.nextFid == noFid {
+		c.nextFid = 0
+	}
+	return fid
+}
+
+// rpc sends req and waits for its response.
+//
+// If ctx is canceled before the response arrives, the request is flushed.
+// Rerror responses are returned as *Error.
+func (c *Client) rpc(ctx context.Context, req message.Content) (message.Content, error) {
+	tag, ch, err := c.newTag()
+	if err != nil {
+		return nil, err
+	}
+	defer c.freeTag(tag)
+
+	if err = c.send(tag, req); err != nil {
+		return nil, err
+	}
+
+	var res message.Content
+	select {
+	case res = <-ch:
+	case <-ctx.Done():
+		// the request might have been answered before the flush, in
+		// which case it took effect
+		res = c.flush(tag, ch)
+		if res == nil {
+			return nil, ctx.Err() //nolint:wrapcheck
+		}
+	case <-c.done:
+		return nil, c.err
+	}
+
+	if re, ok := res.(*message.RError); ok {
+		return nil, &Error{Ename: re.Ename}
+	}
+	return res, nil
+}
+
+// flush flushes the request with oldtag, see flush(5).
+//
+// It returns the response to the request, if it arrived before the Rflush.
+func (c *Client) flush(oldtag uint16, oldch chan message.Content) message.Content {
+	tag, ch, err := c.newTag()
+	if err == nil {
+		defer c.freeTag(tag)
+		err = c.send(tag, &message.TFlush{Oldtag: oldtag})
+	}
+
+	if err == nil {
+		select {
+		case <-ch:
+		case <-c.done:
+		}
+	}
+
+	// responses are handed over in order, so a response to oldtag sent
+	// before the Rflush has already been received
+	select {
+	case res := <-oldch:
+		return res
+	default:
+		return nil
+	}
+}
+
+// call makes the request req and returns its response, which must be a R.
+func call[R message.Content](ctx context.Context, c *Client, req message.Content) (R, error) { //nolint:ireturn
+	var r R
+	res, err := c.rpc(ctx, req)
+	if err != nil {
+		return r, err
+	}
+
+	r, ok := res.(R)
+	if !ok {
+		return r, fmt.Errorf("%w: %T", ErrUnexpected, res)
+	}
+	return r, nil
+}
@@ -0,0 +1,134 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/noonien/np"
+	"github.com/noonien/np/client"
+	"github.com/noonien/np/nptest"
+	"github.com/noonien/np/ramfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openStream serves a stream and returns a fid that has it opened.
+func openStream(t *testing.T) (*nptest.Conn, *client.Fid) {
+	t.Helper()
+
+	s := np.NewStream("events", 4, np.DropOldest)
+	c := nptest.ServePipe(t, s)
+	f, err := c.Root.Walk(context.Background())
+	require.Nil(t, err)
+	require.Nil(t, f.Open(context.Background(), np.ORead))
+	return c, f
+}
+
+func TestConcurrentReads(t *testing.T) {
+	t.Parallel()
+
+	data := make([]byte, 1<<16)
+	rand.New(rand.NewSource(1)).Read(data) //nolint:gosec
+
+	rfs := ramfs.New()
+	require.Nil(t, rfs.WriteFile("f", data, 0o644))
+	c := nptest.ServePipe(t, rfs.Root(), np.NoPermissions())
+
+	ctx := context.Background()
+	f, err := c.Root.Walk(ctx, "f")
+	require.Nil(t, err)
+	require.Nil(t, f.Open(ctx, np.ORead))
+
+	// every reader reads its own chunk, in as many requests as needed
+	const readers = 16
+	chunk := len(data) / readers
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func(off int) {
+			defer wg.Done()
+
+			buf := make([]byte, chunk)
+			for n := 0; n < chunk; {
+				m, err := f.Read(ctx, buf[n:], int64(off+n))
+				if !assert.Nil(t, err) {
+					return
+				}
+				n += m
+			}
+			assert.True(t, bytes.Equal(data[off:off+chunk], buf))
+		}(i * chunk)
+	}
+	wg.Wait()
+}
+
+func TestFlush(t *testing.T) {
+	t.Parallel()
+
+	c, f := openStream(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := f.Read(ctx, make([]byte, 64), 0)
+		errc <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	require.ErrorIs(t, <-errc, context.Canceled)
+
+	// the connection is still usable after the flush
+	_, err := c.Root.Stat(context.Background())
+	require.Nil(t, err)
+}
+
+func TestClose(t *testing.T) {
+	t.Parallel()
+
+	c, f := openStream(t)
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := f.Read(context.Background(), make([]byte, 64), 0)
+		errc <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	require.Nil(t, c.Client.Close())
+	require.ErrorIs(t, <-errc, client.ErrClosed)
+
+	_, err := c.Root.Stat(context.Background())
+	require.ErrorIs(t, err, client.ErrClosed)
+}
+
+func TestErrorIs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		ename  string
+		target error
+		is     bool
+	}{
+		{np.ErrNotFound.Error(), np.ErrNotFound, true},
+		{np.ErrNotFound.Error(), fs.ErrNotExist, true},
+		{np.ErrNotFound.Error(), np.ErrPerm, false},
+		{np.ErrNoWrite.Error(), fs.ErrPermission, true},
+		{np.ErrPerm.Error(), fs.ErrPermission, true},
+		{np.ErrExists.Error(), fs.ErrExist, true},
+		{np.ErrReadOnly.Error(), np.ErrReadOnlyFS, false},
+		{"file does not exist", fs.ErrNotExist, true},
+		{"user not found", fs.ErrNotExist, false},
+		{"FILE NOT FOUND", np.ErrNotFound, false},
+	}
+
+	for _, tt := range tests {
+		err := &client.Error{Ename: tt.ename}
+		require.Equal(t, tt.is, err.Is(tt.target), "%q is %v", tt.ename, tt.target)
+	}
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"io/fs"
+
+	"github.com/noonien/np"
+)
+
+// Error is an error returned by the server.
+type Error struct {
+	Ename string
+}
+
+func (e *Error) Error() string { return e.Ename }
+
+// fsErrors maps the messages of common Plan 9 and Linux errors, that are not
+// np errors, to fs errors.
+var fsErrors = map[string]error{
+	"file does not exist":       fs.ErrNotExist,
+	"No such file or directory": fs.ErrNotExist,
+	"Permission denied":         fs.ErrPermission,
+	"Operation not permitted":   fs.ErrPermission,
+	"file already exists":       fs.ErrExist,
+	"File exists":               fs.ErrExist,
+}
+
+// Is matches the np.Error whose message is Ename, and the fs error for its
+// errno.
+//
+// The server only sends the message, which must match exactly: messages that
+// are neither np errors nor common Plan 9 and Linux errors match nothing.
+func (e *Error) Is(target error) bool {
+	ne, ok := np.ParseError(e.Ename)
+	if !ok {
+		fe, ok := fsErrors[e.Ename]
+		return ok && target == fe //nolint:errorlint // comparing sentinels
+	}
+	if t, ok := target.(np.Error); ok {
+		return t == ne
+	}
+
+	switch target { //nolint:errorlint // comparing sentinels
+	case fs.ErrNotExist:
+		return ne.Errno() == np.ErrNotFound.Errno()
+	case fs.ErrPermission:
+		return ne.Errno() == np.ErrPerm.Errno() || ne.Errno() == np.ErrNoWrite.Errno()
+	case fs.ErrExist:
+		return ne.Errno() == np.ErrExists.Errno()
+	}
+	return false
+}
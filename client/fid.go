@@ -0,0 +1,300 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/noonien/np"
+	"go.rbn.im/neinp/message"
+)
+
+// Fid is a reference to a file on the server.
+//
+// A Fid must be clunked once it is no longer needed, Remove clunks it too.
+type Fid struct {
+	c   *Client
+	fid uint32
+
+	// qid and iounit change with opens and creates, which can be
+	// concurrent with other requests
+	mu     sync.Mutex
+	qid    np.Qid
+	iounit uint32
+}
+
+// Auth starts an authentication of uname, which wants to attach to aname.
+//
+// The conversation is read and written through the returned Fid, which is
+// then passed to Attach.
+func (c *Client) Auth(ctx context.Context, uname, aname string) (*Fid, error) {
+	fid := c.newFid()
+	r, err := call[*message.RAuth](ctx, c, &message.TAuth{
+		Afid:  fid,
+		Uname: uname,
+		Aname: aname,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("auth: %w", err)
+	}
+
+	return &Fid{c: c, fid: fid, qid: r.Aqid}, nil
+}
+
+// Attach attaches uname to the tree aname and returns its root.
+//
+// afid is the Fid returned by Auth, or nil if the server doesn't require
+// authentication.
+func (c *Client) Attach(ctx context.Context, afid *Fid, uname, aname string) (*Fid, error) {
+	af := noFid
+	if afid != nil {
+		af = afid.fid
+	}
+
+	fid := c.newFid()
+	r, err := call[*message.RAttach](ctx, c, &message.TAttach{
+		Fid:   fid,
+		Afid:  af,
+		Uname: uname,
+		Aname: aname,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("attach: %w", err)
+	}
+
+	return &Fid{c: c, fid: fid, qid: r.Qid}, nil
+}
+
+// Qid returns the qid of the file, as of the last walk, open or create.
+func (f *Fid) Qid() np.Qid {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.qid
+}
+
+// Iounit returns the iounit of the opened file, or 0 if the server didn't
+// set one.
+func (f *Fid) Iounit() uint32 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.iounit
+}
+
+// maxCount returns the maximum count of a read or write.
+func (f *Fid) maxCount() int {
+	n := f.c.msize - ioHeaderSize
+	if iounit := f.Iounit(); iounit != 0 && iounit < n {
+		n = iounit
+	}
+	return int(n)
+}
+
+// Walk returns a new Fid for the file found by walking names, starting at f.
+//
+// Walking no names clones f. If only some of the names could be walked, the
+// error is np.ErrNotFound.
+func (f *Fid) Walk(ctx context.Context, names ...string) (*Fid, error) {
+	nf := &Fid{c: f.c, fid: f.c.newFid(), qid: f.Qid()}
+
+	from := f.fid
+	for first := true; first || len(names) > 0; first = false {
+		wnames := names
+		if len(wnames) > maxWelem {
+			wnames = wnames[:maxWelem]
+		}
+		names = names[len(wnames):]
+
+		r, err := call[*message.RWalk](ctx, f.c, &message.TWalk{
+			Fid:    from,
+			Newfid: nf.fid,
+			Wname:  wnames,
+		})
+		if err == nil && len(r.Wqid) != len(wnames) {
+			err = &Error{Ename: np.ErrNotFound.Error()}
+		}
+		if err != nil {
+			if !first {
+				_ = nf.Clunk(ctx)
+			}
+			return nil, fmt.Errorf("walk: %w", err)
+		}
+
+		if len(r.Wqid) > 0 {
+			nf.qid = r.Wqid[len(r.Wqid)-1]
+		}
+		from = nf.fid
+	}
+
+	return nf, nil
+}
+
+// opened sets the qid and iounit of f, once it is opened.
+func (f *Fid) opened(qid np.Qid, iounit uint32) {
+	f.mu.Lock()
+	f.qid = qid
+	f.iounit = iounit
+	f.mu.Unlock()
+}
+
+// Open opens the file for I/O.
+func (f *Fid) Open(ctx context.Context, mode np.OpenMode) error {
+	r, err := call[*message.ROpen](ctx, f.c, &message.TOpen{Fid: f.fid, Mode: mode})
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+
+	f.opened(r.Qid, r.Iounit)
+	return nil
+}
+
+// Create creates the file name in the directory f and opens it, f then
+// points to the new file.
+func (f *Fid) Create(ctx context.Context, name string, perm np.Mode, mode np.OpenMode) error {
+	r, err := call[*message.RCreate](ctx, f.c, &message.TCreate{
+		Fid:  f.fid,
+		Name: name,
+		Perm: perm,
+		Mode: mode,
+	})
+	if err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+
+	f.opened(r.Qid, r.Iounit)
+	return nil
+}
+
+// Read reads up to len(p) bytes starting at off with a single request.
+//
+// It returns io.EOF if there is nothing left to read.
+func (f *Fid) Read(ctx context.Context, p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if len(p) > f.maxCount() {
+		p = p[:f.maxCount()]
+	}
+
+	r, err := call[*message.RRead](ctx, f.c, &message.TRead{
+		Fid:    f.fid,
+		Offset: uint64(off),
+		Count:  uint32(len(p)),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("read: %w", err)
+	}
+
+	if len(r.Data) == 0 {
+		return 0, io.EOF
+	}
+	return copy(p, r.Data), nil
+}
+
+// Write writes p starting at off, in as many requests as needed.
+func (f *Fid) Write(ctx context.Context, p []byte, off int64) (int, error) {
+	var n int
+	for {
+		data := p[n:]
+		if len(data) > f.maxCount() {
+			data = data[:f.maxCount()]
+		}
+
+		r, err := call[*message.RWrite](ctx, f.c, &message.TWrite{
+			Fid:    f.fid,
+			Offset: uint64(off) + uint64(n),
+			Count:  uint32(len(data)),
+			Data:   data,
+		})
+		if err != nil {
+			return n, fmt.Errorf("write: %w", err)
+		}
+
+		n += int(r.Count)
+		if int(r.Count) < len(data) {
+			return n, io.ErrShortWrite
+		}
+		if n == len(p) {
+			return n, nil
+		}
+	}
+}
+
+// ReadDir reads directory entries starting at off, which is 0 or the offset
+// following the last read, and returns them along with the next offset.
+//
+// It returns io.EOF after the last entry.
+func (f *Fid) ReadDir(ctx context.Context, off int64) ([]np.Stat, int64, error) {
+	b := make([]byte, f.maxCount())
+	n, err := f.Read(ctx, b, off)
+	if err != nil {
+		return nil, off, err
+	}
+
+	var sts []np.Stat
+	r := bytes.NewReader(b[:n])
+	for r.Len() > 0 {
+		var st np.Stat
+		if _, err = st.Decode(r); err != nil {
+			return nil, off, fmt.Errorf("read dir: %w", err)
+		}
+		sts = append(sts, st)
+	}
+
+	return sts, off + int64(n), nil
+}
+
+// Stat returns the stat of the file.
+func (f *Fid) Stat(ctx context.Context) (np.Stat, error) {
+	r, err := call[*message.RStat](ctx, f.c, &message.TStat{Fid: f.fid})
+	if err != nil {
+		return np.Stat{}, fmt.Errorf("stat: %w", err)
+	}
+	return r.Stat, nil
+}
+
+// Wstat changes the stat of the file.
+//
+// Fields of st that are set to "don't touch" values are not changed, see
+// DontTouch.
+func (f *Fid) Wstat(ctx context.Context, st np.Stat) error {
+	if _, err := call[*message.RWstat](ctx, f.c, &message.TWstat{Fid: f.fid, Stat: st}); err != nil {
+		return fmt.Errorf("wstat: %w", err)
+	}
+	return nil
+}
+
+// Remove removes the file and clunks f, even if the removal fails.
+func (f *Fid) Remove(ctx context.Context) error {
+	if _, err := call[*message.RRemove](ctx, f.c, &message.TRemove{Fid: f.fid}); err != nil {
+		return fmt.Errorf("remove: %w", err)
+	}
+	return nil
+}
+
+// Clunk forgets the fid.
+func (f *Fid) Clunk(ctx context.Context) error {
+	_, err := call[*message.RClunk](ctx, f.c, &message.TClunk{Fid: f.fid})
+	if err != nil && !errors.Is(err, ErrClosed) {
+		return fmt.Errorf("clunk: %w", err)
+	}
+	return nil
+}
+
+// DontTouch returns a stat that doesn't change anything when passed to
+// Wstat, see stat(5). Fields that should be changed are then set on it.
+func DontTouch() np.Stat {
+	dontTouch := time.Unix(int64(^uint32(0)), 0)
+	return np.Stat{
+		Typ:    ^uint16(0),
+		Dev:    ^uint32(0),
+		Qid:    np.Qid{Type: ^np.QidType(0), Version: ^uint32(0), Path: ^uint64(0)},
+		Mode:   ^np.Mode(0),
+		Atime:  dontTouch,
+		Mtime:  dontTouch,
+		Length: ^uint64(0),
+	}
+}
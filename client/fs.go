@@ -0,0 +1,221 @@
+package client
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/noonien/np"
+)
+
+// FS is an io/fs view of a remote tree.
+type FS struct {
+	root *Fid
+}
+
+var (
+	_ fs.StatFS    = &FS{}
+	_ fs.ReadDirFS = &FS{}
+)
+
+// NewFS returns an FS with root as its root directory.
+//
+// root is only walked from, it is not clunked by FS.
+func NewFS(root *Fid) *FS {
+	return &FS{root: root}
+}
+
+// walk returns a new Fid for name.
+func (fsys *FS) walk(ctx context.Context, op, name string) (*Fid, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	var names []string
+	if name != "." {
+		names = strings.Split(name, "/")
+	}
+
+	f, err := fsys.root.Walk(ctx, names...)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return f, nil
+}
+
+// Open opens name for reading.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	ctx := context.Background()
+
+	f, err := fsys.walk(ctx, "open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = f.Open(ctx, np.ORead); err != nil {
+		_ = f.Clunk(ctx)
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return &file{fid: f, name: name}, nil
+}
+
+// Stat returns the FileInfo of name.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	ctx := context.Background()
+
+	f, err := fsys.walk(ctx, "stat", name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Clunk(ctx) //nolint:errcheck
+
+	st, err := f.Stat(ctx)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return &fileInfo{st: st}, nil
+}
+
+// ReadDir reads the directory name and returns its entries sorted by name.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	d, ok := f.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: np.ErrNotDir}
+	}
+
+	ents, err := d.ReadDir(-1)
+	sortEntries(ents)
+	return ents, err //nolint:wrapcheck
+}
+
+func sortEntries(ents []fs.DirEntry) {
+	sort.Slice(ents, func(i, j int) bool {
+		return ents[i].Name() < ents[j].Name()
+	})
+}
+
+// file is an opened file of an FS.
+type file struct {
+	fid  *Fid
+	name string
+	off  int64
+
+	// dirOff is the offset of the next directory read, ents are read but
+	// not yet returned entries
+	dirOff int64
+	ents   []np.Stat
+	eof    bool
+}
+
+var (
+	_ fs.ReadDirFile = &file{}
+	_ io.ReaderAt    = &file{}
+)
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	st, err := f.fid.Stat(context.Background())
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: err}
+	}
+	return &fileInfo{st: st}, nil
+}
+
+// Read reads a single chunk, it might return less than len(p) bytes before
+// the end of the file.
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.read(p, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	var n int
+	for n < len(p) {
+		m, err := f.read(p[n:], off+int64(n))
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (f *file) read(p []byte, off int64) (int, error) {
+	if f.fid.Qid().Type&np.QTDir != 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: np.ErrIsDir}
+	}
+
+	n, err := f.fid.Read(context.Background(), p, off)
+	if err != nil && err != io.EOF { //nolint:errorlint // Read returns io.EOF as is
+		err = &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	return n, err
+}
+
+func (f *file) ReadDir(n int) ([]fs.DirEntry, error) {
+	if f.fid.Qid().Type&np.QTDir == 0 {
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: np.ErrNotDir}
+	}
+
+	var ents []fs.DirEntry
+	for n <= 0 || len(ents) < n {
+		if len(f.ents) == 0 {
+			if f.eof {
+				break
+			}
+
+			sts, off, err := f.fid.ReadDir(context.Background(), f.dirOff)
+			if err == io.EOF { //nolint:errorlint // ReadDir returns io.EOF as is
+				f.eof = true
+				continue
+			}
+			if err != nil {
+				return ents, &fs.PathError{Op: "readdir", Path: f.name, Err: err}
+			}
+			f.ents, f.dirOff = sts, off
+		}
+
+		ents = append(ents, fs.FileInfoToDirEntry(&fileInfo{st: f.ents[0]}))
+		f.ents = f.ents[1:]
+	}
+
+	if n > 0 && len(ents) == 0 {
+		return nil, io.EOF
+	}
+	return ents, nil
+}
+
+func (f *file) Close() error {
+	return f.fid.Clunk(context.Background())
+}
+
+// fileInfo is the fs.FileInfo of a Stat.
+type fileInfo struct {
+	st np.Stat
+}
+
+func (fi *fileInfo) Name() string {
+	if fi.st.Name == "/" {
+		return "."
+	}
+	return path.Base(fi.st.Name)
+}
+
+func (fi *fileInfo) Size() int64        { return int64(fi.st.Length) }
+func (fi *fileInfo) Mode() fs.FileMode  { return np.FileMode(fi.st.Mode) }
+func (fi *fileInfo) ModTime() time.Time { return fi.st.Mtime }
+func (fi *fileInfo) IsDir() bool        { return fi.st.Mode&np.ModeDir != 0 }
+
+// Sys returns the np.Stat of the file.
+func (fi *fileInfo) Sys() any { return &fi.st }
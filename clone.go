@@ -0,0 +1,330 @@
+package np
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// CloneDir is a directory of numbered sessions, like the protocol directories
+// of Plan 9's /net.
+//
+// Opening the clone file makes a new session, reading the opened clone file
+// returns the number of the session. The session directory is listed as its
+// number, next to the clone file, as long as fids refer to it: the opened
+// clone file, and fids walked to the session directory or its files. Once
+// the last of them is clunked, the session is closed, if it's an io.Closer,
+// and its number is reused.
+type CloneDir struct {
+	// OnClose is called after session id was closed, with the error of its
+	// Close. If it's nil, errors are dropped.
+	OnClose func(id int, err error)
+
+	name       string
+	newSession func(ctx context.Context, id int) (Node, error)
+
+	mu       sync.Mutex
+	sessions map[int]*session
+}
+
+// NewCloneDir returns a CloneDir, newSession returns the directory of the
+// session with the number id.
+func NewCloneDir(name string, newSession func(ctx context.Context, id int) (Node, error)) *CloneDir {
+	return &CloneDir{
+		name:       name,
+		newSession: newSession,
+		sessions:   make(map[int]*session),
+	}
+}
+
+var (
+	_ Dir        = &CloneDir{}
+	_ ContextDir = &CloneDir{}
+)
+
+func (cd *CloneDir) Stat() (Stat, error) {
+	return Stat{
+		Qid:  Qid{Type: QTDir},
+		Mode: ModeDir | 0o555,
+		Name: cd.name,
+	}, nil
+}
+
+func (cd *CloneDir) Children() ([]Stat, error) {
+	return cd.ChildrenContext(context.Background())
+}
+
+func (cd *CloneDir) ChildrenContext(ctx context.Context) ([]Stat, error) {
+	cst, err := (&cloneFile{cd: cd}).Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	cd.mu.Lock()
+	ids := make([]int, 0, len(cd.sessions))
+	for id := range cd.sessions {
+		ids = append(ids, id)
+	}
+	cd.mu.Unlock()
+	sort.Ints(ids)
+
+	sts := []Stat{cst}
+	for _, id := range ids {
+		se, ok := cd.session(id)
+		if !ok {
+			continue
+		}
+
+		// sessions that are still being made or were closed since are
+		// not listed
+		node, _, err := se.dir()
+		if err != nil {
+			continue
+		}
+
+		st, err := se.stat(ctx, node)
+		if err != nil {
+			return nil, err
+		}
+		sts = append(sts, st)
+	}
+	return sts, nil
+}
+
+func (cd *CloneDir) Walk(name string) (Node, error) { //nolint:ireturn
+	return cd.WalkContext(context.Background(), name)
+}
+
+func (cd *CloneDir) WalkContext(ctx context.Context, name string) (Node, error) { //nolint:ireturn
+	if name == "clone" {
+		return &cloneFile{cd: cd}, nil
+	}
+
+	id, err := strconv.Atoi(name)
+	if err != nil || strconv.Itoa(id) != name {
+		return nil, ErrNotFound
+	}
+
+	se, ok := cd.session(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return se, nil
+}
+
+// session returns the session id, if it's open.
+func (cd *CloneDir) session(id int) (*session, bool) {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+
+	se, ok := cd.sessions[id]
+	return se, ok
+}
+
+// clone makes a new session, with the lowest free number. The session has
+// a reference, for the opened clone file.
+func (cd *CloneDir) clone(ctx context.Context) (*session, error) {
+	cd.mu.Lock()
+	id := 0
+	for cd.sessions[id] != nil {
+		id++
+	}
+
+	// the number is reserved while the session is made
+	se := &session{cd: cd, id: id, refs: 1}
+	cd.sessions[id] = se
+	cd.mu.Unlock()
+
+	node, err := cd.newSession(ctx, id)
+	if err == nil {
+		if _, ok := UnwrapValue[Dir](node); !ok {
+			err = ErrNotDir
+		}
+	}
+
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+
+	if err != nil {
+		delete(cd.sessions, id)
+		return nil, err
+	}
+	se.node = node
+	return se, nil
+}
+
+// session is a session of a CloneDir.
+type session struct {
+	cd *CloneDir
+	id int
+
+	// node is nil while the session is made, the rest is guarded by the
+	// CloneDir's mu
+	node   Node
+	refs   int
+	closed bool
+}
+
+var (
+	_ Dir        = &session{}
+	_ ContextDir = &session{}
+	_ Referencer = &session{}
+	_ Identifier = &session{}
+)
+
+// dir returns the directory of the session, or ErrNotFound if it's closed or
+// still being made.
+func (se *session) dir() (Node, Dir, error) { //nolint:ireturn
+	se.cd.mu.Lock()
+	node := se.node
+	closed := se.closed
+	se.cd.mu.Unlock()
+
+	if node == nil || closed {
+		return nil, nil, ErrNotFound
+	}
+
+	d, _ := UnwrapValue[Dir](node)
+	return node, d, nil
+}
+
+func (se *session) Stat() (Stat, error) {
+	return se.StatContext(context.Background())
+}
+
+func (se *session) StatContext(ctx context.Context) (Stat, error) {
+	node, _, err := se.dir()
+	if err != nil {
+		return Stat{}, err
+	}
+	return se.stat(ctx, node)
+}
+
+// stat returns the stat of the session, whose directory is node.
+func (se *session) stat(ctx context.Context, node Node) (Stat, error) {
+	st, err := StatNode(ctx, node)
+	if err != nil {
+		return Stat{}, err
+	}
+	// the qid is given by the server, from the identity of the session
+	st.Qid = Qid{Type: QTDir}
+	st.Mode |= ModeDir
+	st.Name = strconv.Itoa(se.id)
+	return st, nil
+}
+
+func (se *session) Children() ([]Stat, error) {
+	return se.ChildrenContext(context.Background())
+}
+
+func (se *session) ChildrenContext(ctx context.Context) ([]Stat, error) {
+	_, d, err := se.dir()
+	if err != nil {
+		return nil, err
+	}
+	return DirChildren(ctx, d)
+}
+
+func (se *session) Walk(name string) (Node, error) { //nolint:ireturn
+	return se.WalkContext(context.Background(), name)
+}
+
+func (se *session) WalkContext(ctx context.Context, name string) (Node, error) { //nolint:ireturn
+	_, d, err := se.dir()
+	if err != nil {
+		return nil, err
+	}
+	return WalkDir(ctx, d, name)
+}
+
+// Identity makes sessions that reuse a number get new qids.
+func (se *session) Identity() any {
+	return se
+}
+
+func (se *session) Ref() {
+	se.cd.mu.Lock()
+	defer se.cd.mu.Unlock()
+
+	se.refs++
+}
+
+// Unref closes the session when the last reference is dropped. Fids that
+// were walked to the session while it was closed don't open it again.
+func (se *session) Unref() {
+	se.cd.mu.Lock()
+	se.refs--
+	if se.refs > 0 || se.closed {
+		se.cd.mu.Unlock()
+		return
+	}
+
+	se.closed = true
+	delete(se.cd.sessions, se.id)
+	node := se.node
+	se.cd.mu.Unlock()
+
+	var err error
+	if c, ok := UnwrapValue[io.Closer](node); ok {
+		err = c.Close()
+	}
+
+	if se.cd.OnClose != nil {
+		se.cd.OnClose(se.id, err)
+	}
+}
+
+// cloneFile is the clone file of a CloneDir.
+type cloneFile struct {
+	cd *CloneDir
+}
+
+var _ ContextOpener = &cloneFile{}
+
+func (cf *cloneFile) Stat() (Stat, error) {
+	return Stat{
+		Qid:  Qid{Type: QTFile},
+		Mode: 0o444,
+		Name: "clone",
+	}, nil
+}
+
+func (cf *cloneFile) Open(mode OpenMode) (any, uint32, error) {
+	return cf.OpenContext(context.Background(), mode)
+}
+
+// OpenContext makes a new session, which lives at least until the clone
+// file is clunked.
+func (cf *cloneFile) OpenContext(ctx context.Context, mode OpenMode) (any, uint32, error) {
+	se, err := cf.cd.clone(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &cloneFD{se: se, id: []byte(strconv.Itoa(se.id))}, 0, nil
+}
+
+// cloneFD is an opened clone file.
+type cloneFD struct {
+	se *session
+	id []byte
+}
+
+var (
+	_ io.ReaderAt = &cloneFD{}
+	_ io.Closer   = &cloneFD{}
+)
+
+// ReadAt returns the number of the session.
+func (fd *cloneFD) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(fd.id)) {
+		return 0, io.EOF
+	}
+	return copy(p, fd.id[off:]), nil
+}
+
+func (fd *cloneFD) Close() error {
+	fd.se.Unref()
+	return nil
+}
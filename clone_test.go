@@ -0,0 +1,113 @@
+package np_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/noonien/np"
+	"github.com/noonien/np/nptest"
+	"github.com/noonien/np/ramfs"
+	"github.com/stretchr/testify/require"
+)
+
+// closingFS is a session that fails to close.
+type closingFS struct {
+	np.Node
+}
+
+func (c *closingFS) Unwrap() any  { return c.Node }
+func (c *closingFS) Close() error { return errors.New("close failed") }
+
+func TestCloneDir(t *testing.T) {
+	t.Parallel()
+
+	closed := make(chan error, 10)
+	cd := np.NewCloneDir("net", func(ctx context.Context, id int) (np.Node, error) {
+		rfs := ramfs.New()
+		if err := rfs.WriteFile("data", []byte("data"), 0o444); err != nil {
+			return nil, err
+		}
+		return &closingFS{Node: rfs.Root()}, nil
+	})
+	cd.OnClose = func(id int, err error) {
+		closed <- err
+	}
+
+	c := nptest.ServePipe(t, cd)
+	ctx := context.Background()
+
+	clone, err := c.Root.Walk(ctx, "clone")
+	require.Nil(t, err)
+	require.Nil(t, clone.Open(ctx, np.ORead))
+
+	buf := make([]byte, 8)
+	n, err := clone.Read(ctx, buf, 0)
+	require.Nil(t, err)
+	require.Equal(t, "0", string(buf[:n]))
+
+	dir, err := c.Root.Walk(ctx, "0")
+	require.Nil(t, err)
+	qid := dir.Qid()
+	data, err := dir.Walk(ctx, "data")
+	require.Nil(t, err)
+
+	// the session lives as long as fids refer to it
+	require.Nil(t, clone.Clunk(ctx))
+	require.Nil(t, dir.Clunk(ctx))
+	b, err := fs.ReadFile(c, "0/data")
+	require.Nil(t, err)
+	require.Equal(t, "data", string(b))
+	require.Empty(t, closed)
+
+	require.Nil(t, data.Clunk(ctx))
+	require.EqualError(t, <-closed, "close failed")
+	_, err = fs.Stat(c, "0")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	// the number is reused, by a new file
+	clone, err = c.Root.Walk(ctx, "clone")
+	require.Nil(t, err)
+	require.Nil(t, clone.Open(ctx, np.ORead))
+	n, err = clone.Read(ctx, buf, 0)
+	require.Nil(t, err)
+	require.Equal(t, "0", string(buf[:n]))
+
+	dir, err = c.Root.Walk(ctx, "0")
+	require.Nil(t, err)
+	require.NotEqual(t, qid.Path, dir.Qid().Path)
+}
+
+func TestCloneDirListing(t *testing.T) {
+	t.Parallel()
+
+	started, release := make(chan struct{}), make(chan struct{})
+	cd := np.NewCloneDir("net", func(ctx context.Context, id int) (np.Node, error) {
+		close(started)
+		<-release
+		return ramfs.New().Root(), nil
+	})
+
+	c := nptest.ServePipe(t, cd)
+	ctx := context.Background()
+
+	clone, err := c.Root.Walk(ctx, "clone")
+	require.Nil(t, err)
+	opened := make(chan error, 1)
+	go func() { opened <- clone.Open(ctx, np.ORead) }()
+	<-started
+
+	// the session that is being made is not listed yet
+	ents, err := fs.ReadDir(c, ".")
+	require.Nil(t, err)
+	require.Len(t, ents, 1)
+	require.Equal(t, "clone", ents[0].Name())
+
+	close(release)
+	require.Nil(t, <-opened)
+	ents, err = fs.ReadDir(c, ".")
+	require.Nil(t, err)
+	require.Len(t, ents, 2)
+	require.Equal(t, "0", ents[0].Name())
+}
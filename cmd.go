@@ -14,12 +14,13 @@ type LineCmdRecv struct {
 
 type lineCmdFD struct {
 	lcr *LineCmdRecv
-	buf bytes.Buffer
+	lb  lineBuf
 }
 
 var (
 	_ Opener      = &LineCmdRecv{}
 	_ io.WriterAt = &lineCmdFD{}
+	_ io.Closer   = &lineCmdFD{}
 )
 
 func (lcr *LineCmdRecv) Open(mode message.OpenMode) (any, uint32, error) {
@@ -27,35 +28,64 @@ func (lcr *LineCmdRecv) Open(mode message.OpenMode) (any, uint32, error) {
 }
 
 func (fd *lineCmdFD) WriteAt(p []byte, off int64) (int, error) {
+	return fd.lb.write(p, fd.lcr.Handler)
+}
+
+// Close handles the last line, if it's not terminated by a newline.
+func (fd *lineCmdFD) Close() error {
+	return fd.lb.flush(fd.lcr.Handler)
+}
+
+// lineBuf splits writes into lines.
+type lineBuf struct {
+	buf bytes.Buffer
+}
+
+// write calls handle for every line completed by p, the rest of p is kept
+// until it's completed by the next writes.
+func (lb *lineBuf) write(p []byte, handle func(string) error) (int, error) {
 	var n int
 	for {
 		idx := bytes.IndexByte(p, '\n')
 		if idx < 0 {
 			n += len(p)
-			fd.buf.Write(p)
+			lb.buf.Write(p)
 			break
 		}
 
 		cmd := p[:idx]
-		if fd.buf.Len() > 0 {
-			fd.buf.Write(p[:idx])
-			cmd = fd.buf.Bytes()
+		if lb.buf.Len() > 0 {
+			lb.buf.Write(p[:idx])
+			cmd = lb.buf.Bytes()
 		}
 
 		scmd := string(cmd)
 		scmd = strings.TrimSpace(scmd)
 
+		// the line is dropped even if it fails
+		p = p[idx+1:]
+		lb.buf.Reset()
+
 		if len(cmd) > 0 {
-			err := fd.lcr.Handler(scmd)
+			err := handle(scmd)
 			if err != nil {
 				return n, err
 			}
 		}
 
-		p = p[idx+1:]
 		n += idx + 1
-		fd.buf.Reset()
 	}
 
 	return n, nil
 }
+
+// flush calls handle for the line that is kept, if any.
+func (lb *lineBuf) flush(handle func(string) error) error {
+	if lb.buf.Len() == 0 {
+		return nil
+	}
+
+	scmd := strings.TrimSpace(lb.buf.String())
+	lb.buf.Reset()
+	return handle(scmd)
+}
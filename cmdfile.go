@@ -0,0 +1,398 @@
+package np
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ArgKind is the type of a command argument.
+type ArgKind int
+
+const (
+	// ArgString is any word.
+	ArgString ArgKind = iota
+
+	// ArgInt and ArgUint are integers, as parsed by strconv with base 0.
+	ArgInt
+	ArgUint
+
+	// ArgBool is a bool as parsed by strconv, or on and off.
+	ArgBool
+
+	// ArgDuration is a duration as parsed by time.ParseDuration.
+	ArgDuration
+)
+
+// CmdArg describes an argument of a command.
+type CmdArg struct {
+	Name string
+	Kind ArgKind
+
+	// Optional arguments can be left out, they must come after the other
+	// arguments. A Variadic argument takes all the remaining words, it
+	// must be the last one.
+	Optional bool
+	Variadic bool
+}
+
+// Cmd is a command of a CmdFile.
+type Cmd struct {
+	Name string
+	Args []CmdArg
+	Run  func(ctx context.Context, args CmdArgs) error
+}
+
+// Usage returns the usage of the command, optional arguments are in
+// brackets, variadic ones are followed by "...".
+func (c *Cmd) Usage() string {
+	var sb strings.Builder
+	sb.WriteString(c.Name)
+	for _, a := range c.Args {
+		name := a.Name
+		if a.Variadic {
+			name += "..."
+		}
+		if a.Optional {
+			name = "[" + name + "]"
+		}
+		sb.WriteString(" " + name)
+	}
+	return sb.String()
+}
+
+// parse checks words against the arguments of the command.
+func (c *Cmd) parse(words []string) (CmdArgs, error) {
+	args := CmdArgs{vals: make(map[string][]string)}
+	usage := Error{err: "usage: " + c.Usage(), errno: einval}
+
+	for i, a := range c.Args {
+		if i >= len(words) {
+			if !a.Optional {
+				return CmdArgs{}, usage
+			}
+			break
+		}
+
+		vals := words[i : i+1]
+		if a.Variadic {
+			vals = words[i:]
+		}
+
+		for _, v := range vals {
+			if err := checkArg(a.Kind, v); err != nil {
+				return CmdArgs{}, usage
+			}
+		}
+		args.vals[a.Name] = vals
+	}
+
+	if n := len(c.Args); len(words) > n && (n == 0 || !c.Args[n-1].Variadic) {
+		return CmdArgs{}, usage
+	}
+	return args, nil
+}
+
+// checkArg returns an error if v is not of kind.
+func checkArg(kind ArgKind, v string) error {
+	var err error
+	switch kind {
+	case ArgString:
+	case ArgInt:
+		_, err = strconv.ParseInt(v, 0, 64)
+	case ArgUint:
+		_, err = strconv.ParseUint(v, 0, 64)
+	case ArgBool:
+		_, err = parseBool(v)
+	case ArgDuration:
+		_, err = time.ParseDuration(v)
+	}
+	return err //nolint:wrapcheck
+}
+
+// parseBool parses v as a bool, on and off are also accepted.
+func parseBool(v string) (bool, error) {
+	switch v {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	}
+	return strconv.ParseBool(v) //nolint:wrapcheck
+}
+
+// CmdArgs are the arguments a command was run with, by name. Missing
+// arguments have the zero value.
+type CmdArgs struct {
+	vals map[string][]string
+}
+
+// Has returns true if the argument name was given.
+func (a CmdArgs) Has(name string) bool {
+	return len(a.vals[name]) > 0
+}
+
+// String returns the argument name.
+func (a CmdArgs) String(name string) string {
+	if vs := a.vals[name]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+// Strings returns the words of the variadic argument name.
+func (a CmdArgs) Strings(name string) []string {
+	return a.vals[name]
+}
+
+// Int returns the argument name, of kind ArgInt.
+func (a CmdArgs) Int(name string) int64 {
+	v, _ := strconv.ParseInt(a.String(name), 0, 64)
+	return v
+}
+
+// Uint returns the argument name, of kind ArgUint.
+func (a CmdArgs) Uint(name string) uint64 {
+	v, _ := strconv.ParseUint(a.String(name), 0, 64)
+	return v
+}
+
+// Bool returns the argument name, of kind ArgBool.
+func (a CmdArgs) Bool(name string) bool {
+	v, _ := parseBool(a.String(name))
+	return v
+}
+
+// Duration returns the argument name, of kind ArgDuration.
+func (a CmdArgs) Duration(name string) time.Duration {
+	v, _ := time.ParseDuration(a.String(name))
+	return v
+}
+
+// CmdFile is a control file that runs the commands written to it, one per
+// line. Lines are split into words with Tokenize, the first word is the name
+// of the command. A last line that is not terminated by a newline is run
+// when the file is clunked, with the context of the clunk; its error is the
+// error of the clunk, the fid is clunked anyway.
+//
+// Unknown commands and wrong arguments fail the write with a usage error.
+// Errors of commands are sent to the client as they are, see Error.
+//
+// Reading the file returns its State, or the usage of its commands if State
+// is nil.
+type CmdFile struct {
+	Name  string
+	State func(ctx context.Context) ([]byte, error)
+
+	mu   sync.RWMutex
+	cmds []*Cmd
+}
+
+// Register adds cmd to the file.
+func (cf *CmdFile) Register(cmd Cmd) error {
+	if cmd.Name == "" || cmd.Run == nil {
+		return fmt.Errorf("command %q: %w", cmd.Name, ErrInvalidArg)
+	}
+
+	optional := false
+	for i, a := range cmd.Args {
+		if a.Variadic && i != len(cmd.Args)-1 {
+			return fmt.Errorf("command %q: variadic argument %q is not last: %w", cmd.Name, a.Name, ErrInvalidArg)
+		}
+		if optional && !a.Optional {
+			return fmt.Errorf("command %q: argument %q follows optional arguments: %w", cmd.Name, a.Name, ErrInvalidArg)
+		}
+		optional = a.Optional
+	}
+
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	if cf.lookup(cmd.Name) != nil {
+		return fmt.Errorf("command %q: %w", cmd.Name, ErrExists)
+	}
+	cf.cmds = append(cf.cmds, &cmd)
+	return nil
+}
+
+// lookup returns the command name, cf.mu must be held.
+func (cf *CmdFile) lookup(name string) *Cmd {
+	for _, c := range cf.cmds {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Usage returns the usage of the commands, one per line.
+func (cf *CmdFile) Usage() string {
+	cf.mu.RLock()
+	defer cf.mu.RUnlock()
+
+	var sb strings.Builder
+	for _, c := range cf.cmds {
+		sb.WriteString(c.Usage() + "\n")
+	}
+	return sb.String()
+}
+
+// Run runs the command line.
+func (cf *CmdFile) Run(ctx context.Context, line string) error {
+	words := Tokenize(line)
+	if len(words) == 0 {
+		return nil
+	}
+
+	cf.mu.RLock()
+	cmd := cf.lookup(words[0])
+	cf.mu.RUnlock()
+
+	if cmd == nil {
+		return Error{err: "unknown command " + strconv.Quote(words[0]), errno: einval}
+	}
+
+	args, err := cmd.parse(words[1:])
+	if err != nil {
+		return err
+	}
+
+	if err = cmd.Run(ctx, args); err != nil {
+		return cmdError(err)
+	}
+	return nil
+}
+
+// cmdError returns err as an Error, so that its message is sent to the
+// client.
+func cmdError(err error) error {
+	var ne Error
+	if errors.As(err, &ne) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return Error{err: err.Error(), errno: eio}
+}
+
+var (
+	_ Node          = &CmdFile{}
+	_ ContextOpener = &CmdFile{}
+)
+
+func (cf *CmdFile) Stat() (Stat, error) {
+	return Stat{
+		Qid:  Qid{Type: QTFile},
+		Mode: 0o666,
+		Name: cf.Name,
+	}, nil
+}
+
+func (cf *CmdFile) Open(mode OpenMode) (any, uint32, error) {
+	return cf.OpenContext(context.Background(), mode)
+}
+
+// OpenContext gets the State of the file, if it's opened for reading. It's
+// read until the file is clunked.
+func (cf *CmdFile) OpenContext(ctx context.Context, mode OpenMode) (any, uint32, error) {
+	fd := &cmdFD{cf: cf}
+	if mode&3 == OWrite {
+		return fd, 0, nil
+	}
+
+	if cf.State == nil {
+		fd.data = []byte(cf.Usage())
+		return fd, 0, nil
+	}
+
+	data, err := cf.State(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	fd.data = data
+	return fd, 0, nil
+}
+
+// cmdFD is an opened CmdFile.
+type cmdFD struct {
+	cf   *CmdFile
+	data []byte
+	lb   lineBuf
+}
+
+var (
+	_ io.ReaderAt     = &cmdFD{}
+	_ ContextWriterAt = &cmdFD{}
+	_ io.Closer       = &cmdFD{}
+	_ ContextCloser   = &cmdFD{}
+)
+
+func (fd *cmdFD) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(fd.data)) {
+		return 0, io.EOF
+	}
+	return copy(p, fd.data[off:]), nil
+}
+
+func (fd *cmdFD) WriteAtContext(ctx context.Context, p []byte, off int64) (int, error) {
+	return fd.lb.write(p, func(line string) error {
+		return fd.cf.Run(ctx, line)
+	})
+}
+
+func (fd *cmdFD) Close() error {
+	return fd.CloseContext(context.Background())
+}
+
+// CloseContext runs the last line, if it's not terminated by a newline.
+func (fd *cmdFD) CloseContext(ctx context.Context) error {
+	return fd.lb.flush(func(line string) error {
+		return fd.cf.Run(ctx, line)
+	})
+}
+
+// Tokenize splits s into words, like tokenize of Plan 9's libc. Words are
+// separated by white space, which can be quoted with single quotes. Within
+// quotes, two single quotes stand for one.
+//
+// For example, the line
+//
+//	echo 'hello world' 'it''s'
+//
+// is split into echo, "hello world" and "it's".
+func Tokenize(s string) []string {
+	var words []string
+	var word strings.Builder
+	inWord, quoted := false, false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\'':
+			if quoted && i+1 < len(s) && s[i+1] == '\'' {
+				word.WriteByte(c)
+				i++
+			} else {
+				quoted = !quoted
+			}
+			inWord = true
+		case !quoted && (c == ' ' || c == '\t' || c == '\r' || c == '\n'):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
@@ -0,0 +1,154 @@
+package np_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/noonien/np"
+	"github.com/noonien/np/nptest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenize(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		line  string
+		words []string
+	}{
+		{"", nil},
+		{" \t\n", nil},
+		{"echo", []string{"echo"}},
+		{"  echo  a\tb\r\n", []string{"echo", "a", "b"}},
+		{"echo 'hello world' 'it''s'", []string{"echo", "hello world", "it's"}},
+		{"a''b", []string{"ab"}},
+		{"''", []string{""}},
+		{"'' x", []string{"", "x"}},
+		{"a'b c'd", []string{"ab cd"}},
+		{"'''", []string{"'"}},
+		{"'unterminated quote", []string{"unterminated quote"}},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.words, np.Tokenize(tt.line), tt.line)
+	}
+}
+
+func TestCmdParse(t *testing.T) {
+	t.Parallel()
+
+	var got np.CmdArgs
+	run := func(ctx context.Context, args np.CmdArgs) error {
+		got = args
+		return nil
+	}
+
+	cf := &np.CmdFile{}
+	require.Nil(t, cf.Register(np.Cmd{Name: "none", Run: run}))
+	require.Nil(t, cf.Register(np.Cmd{
+		Name: "set",
+		Args: []np.CmdArg{
+			{Name: "n", Kind: np.ArgInt},
+			{Name: "on", Kind: np.ArgBool, Optional: true},
+			{Name: "d", Kind: np.ArgDuration, Optional: true},
+		},
+		Run: run,
+	}))
+	require.Nil(t, cf.Register(np.Cmd{
+		Name: "echo",
+		Args: []np.CmdArg{
+			{Name: "u", Kind: np.ArgUint},
+			{Name: "words", Variadic: true, Optional: true},
+		},
+		Run: run,
+	}))
+
+	tests := []struct {
+		line  string
+		err   string
+		check func(args np.CmdArgs)
+	}{
+		{line: "none"},
+		{line: "none x", err: "usage: none"},
+		{line: "nope", err: `unknown command "nope"`},
+		{line: "set", err: "usage: set n [on] [d]"},
+		{line: "set x", err: "usage: set n [on] [d]"},
+		{line: "set 1 on 1s 2", err: "usage: set n [on] [d]"},
+		{line: "set 0x10", check: func(args np.CmdArgs) {
+			require.Equal(t, int64(16), args.Int("n"))
+			require.False(t, args.Has("on"))
+			require.False(t, args.Bool("on"))
+		}},
+		{line: "set -1 on 1m", check: func(args np.CmdArgs) {
+			require.Equal(t, int64(-1), args.Int("n"))
+			require.True(t, args.Bool("on"))
+			require.Equal(t, "1m0s", args.Duration("d").String())
+		}},
+		{line: "set 1 maybe", err: "usage: set n [on] [d]"},
+		{line: "echo -1", err: "usage: echo u [words...]"},
+		{line: "echo 1", check: func(args np.CmdArgs) {
+			require.Equal(t, uint64(1), args.Uint("u"))
+			require.Nil(t, args.Strings("words"))
+		}},
+		{line: "echo 1 'a b' c", check: func(args np.CmdArgs) {
+			require.Equal(t, []string{"a b", "c"}, args.Strings("words"))
+			require.Equal(t, "a b", args.String("words"))
+		}},
+	}
+
+	for _, tt := range tests {
+		got = np.CmdArgs{}
+		err := cf.Run(context.Background(), tt.line)
+		if tt.err != "" {
+			require.EqualError(t, err, tt.err, tt.line)
+			continue
+		}
+		require.Nil(t, err, tt.line)
+		if tt.check != nil {
+			tt.check(got)
+		}
+	}
+}
+
+func TestCmdFileClunk(t *testing.T) {
+	t.Parallel()
+
+	var lines []string
+	cf := &np.CmdFile{Name: "ctl"}
+	require.Nil(t, cf.Register(np.Cmd{
+		Name: "add",
+		Args: []np.CmdArg{{Name: "n", Kind: np.ArgInt}},
+		Run: func(ctx context.Context, args np.CmdArgs) error {
+			lines = append(lines, args.String("n"))
+			return nil
+		},
+	}))
+
+	c := nptest.ServePipe(t, cf)
+	ctx := context.Background()
+
+	f, err := c.Root.Walk(ctx)
+	require.Nil(t, err)
+	require.Nil(t, f.Open(ctx, np.OWrite))
+
+	// complete lines are run as they are written
+	_, err = f.Write(ctx, []byte("add 1\nadd"), 0)
+	require.Nil(t, err)
+	require.Equal(t, []string{"1"}, lines)
+	_, err = f.Write(ctx, []byte(" 2"), 0)
+	require.Nil(t, err)
+	require.Equal(t, []string{"1"}, lines)
+
+	// the last line is run on clunk
+	require.Nil(t, f.Clunk(ctx))
+	require.Equal(t, []string{"1", "2"}, lines)
+
+	// and its error is the error of the clunk
+	f, err = c.Root.Walk(ctx)
+	require.Nil(t, err)
+	require.Nil(t, f.Open(ctx, np.OWrite))
+	_, err = f.Write(ctx, []byte("add x"), 0)
+	require.Nil(t, err)
+	require.EqualError(t, f.Clunk(ctx), "clunk: usage: add n")
+	require.Equal(t, []string{"1", "2"}, lines)
+}
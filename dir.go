@@ -0,0 +1,292 @@
+package np
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// dir is an opened directory.
+//
+// Children are pulled from a DirIterator as the directory is read. Only the
+// entries of the last read are kept, so clients can read them again, reading
+// from offset 0 starts a new listing.
+type dir struct {
+	Node
+	Dir
+
+	s     *server
+	aname string
+	path  []string
+	list  func(ctx context.Context) (DirIterator, error)
+
+	mu      sync.Mutex
+	it      DirIterator
+	eof     bool
+	started bool
+
+	// ents are the filled stats of the entries starting at index base,
+	// which starts at baseOff in 9P2000 reads
+	ents    []Stat
+	base    uint64
+	baseOff int64
+}
+
+var (
+	_ ContextReaderAt = &dir{}
+	_ io.Closer       = &dir{}
+)
+
+func (s *server) newDir(ctx context.Context, n Node, d Dir, aname string, path []string) (*dir, error) {
+	dr := &dir{
+		Node:  n,
+		Dir:   d,
+		s:     s,
+		aname: aname,
+		path:  append([]string{}, path...),
+	}
+
+	if dl, ok := d.(DirLister); ok {
+		dr.list = dl.List
+	} else {
+		dr.list = func(ctx context.Context) (DirIterator, error) {
+			cs, err := DirChildren(ctx, d)
+			if err != nil {
+				return nil, err
+			}
+			return &statIterator{stats: cs}, nil
+		}
+	}
+
+	if err := dr.restart(ctx); err != nil {
+		return nil, err
+	}
+	return dr, nil
+}
+
+// restart starts a new listing.
+func (d *dir) restart(ctx context.Context) error {
+	if err := d.closeIter(); err != nil {
+		return err
+	}
+
+	it, err := d.list(ctx)
+	if err != nil {
+		return fmt.Errorf("children: %w", err)
+	}
+
+	d.it = it
+	d.eof = false
+	d.started = false
+	d.ents = nil
+	d.base = 0
+	d.baseOff = 0
+	return nil
+}
+
+func (d *dir) closeIter() error {
+	c, ok := d.it.(io.Closer)
+	d.it = nil
+	if ok {
+		if err := c.Close(); err != nil {
+			return fmt.Errorf("close: %w", err)
+		}
+	}
+	return nil
+}
+
+// entry returns the entry at index i, or nil after the last entry.
+//
+// hint is the number of entries that are likely to be needed.
+func (d *dir) entry(ctx context.Context, i uint64, hint int) (*Stat, error) {
+	if i < d.base {
+		return nil, ErrBadOffset
+	}
+
+	for i >= d.base+uint64(len(d.ents)) {
+		if d.eof {
+			return nil, nil //nolint:nilnil
+		}
+
+		cs, err := d.it.Next(ctx, hint)
+		if errors.Is(err, io.EOF) || (err == nil && len(cs) == 0) {
+			d.eof = true
+		} else if err != nil {
+			return nil, fmt.Errorf("children: %w", err)
+		}
+
+		cpath := make([]string, len(d.path), len(d.path)+1)
+		copy(cpath, d.path)
+		for j := range cs {
+			st := &cs[j]
+			if err = d.s.fillstat(d.aname, nil, st, false, append(cpath, st.Name)...); err != nil {
+				return nil, err
+			}
+		}
+		d.ents = append(d.ents, cs...)
+	}
+
+	return &d.ents[i-d.base], nil
+}
+
+// forget drops the entries before index i, which starts at off.
+func (d *dir) forget(i uint64, off int64) {
+	if i <= d.base {
+		return
+	}
+
+	n := i - d.base
+	if n > uint64(len(d.ents)) {
+		n = uint64(len(d.ents))
+	}
+	d.ents = d.ents[n:]
+	d.base += n
+	d.baseOff = off
+}
+
+// ReadAtContext reads whole directory entries, starting at off.
+//
+// off must be the offset of an entry returned by the last read, or the end of
+// the last read. If the first entry doesn't fit in p, it returns ErrBadCount.
+func (d *dir) ReadAtContext(ctx context.Context, p []byte, off int64) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if off == 0 && d.started {
+		if err := d.restart(ctx); err != nil {
+			return 0, err
+		}
+	}
+	d.started = true
+
+	dotu := d.s.dialect() == dialectU
+	hint := len(p)/64 + 1
+	encode := func(b *wbuf, st *Stat) {
+		if dotu {
+			us := unixStat(st)
+			b.stat(st, &us)
+		} else {
+			b.stat(st, nil)
+		}
+	}
+
+	// find the entry at off
+	i, o := d.base, d.baseOff
+	for o < off {
+		st, err := d.entry(ctx, i, hint)
+		if err != nil {
+			return 0, err
+		}
+		if st == nil {
+			break
+		}
+
+		var b wbuf
+		encode(&b, st)
+		o += int64(len(b.b))
+		i++
+	}
+	if o != off {
+		return 0, ErrBadOffset
+	}
+
+	var b wbuf
+	start := i
+	for {
+		st, err := d.entry(ctx, i, hint)
+		if err != nil {
+			if len(b.b) > 0 {
+				break
+			}
+			return 0, err
+		}
+		if st == nil {
+			if len(b.b) == 0 {
+				return 0, io.EOF
+			}
+			break
+		}
+
+		n := len(b.b)
+		encode(&b, st)
+		if len(b.b) > len(p) {
+			// an entry can't be split over reads
+			if n == 0 {
+				return 0, ErrBadCount
+			}
+			b.b = b.b[:n]
+			break
+		}
+		i++
+	}
+
+	d.forget(start, off)
+	return copy(p, b.b), nil
+}
+
+// readdir returns 9P2000.L directory entries, starting with the entry at
+// index offset, that fit in count bytes. If the first entry doesn't fit, it
+// returns ErrBadCount.
+//
+// The entries before offset are dropped. Reading at an earlier offset, as
+// Linux does after a short getdents buffer, restarts the listing.
+func (d *dir) readdir(ctx context.Context, offset uint64, count int) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if (offset == 0 && d.started) || offset < d.base {
+		if err := d.restart(ctx); err != nil {
+			return nil, err
+		}
+	}
+	d.started = true
+
+	b := &wbuf{}
+	hint := count/32 + 1
+	for i := offset; ; i++ {
+		st, err := d.entry(ctx, i, hint)
+		if err != nil {
+			if len(b.b) > 0 {
+				break
+			}
+			return nil, err
+		}
+		if st == nil {
+			break
+		}
+		if len(b.b)+direntSize(st.Name) > count {
+			if len(b.b) == 0 {
+				return nil, ErrBadCount
+			}
+			break
+		}
+		b.dirent(st.Qid, i+1, direntType(st.Mode), st.Name)
+	}
+
+	d.forget(offset, 0)
+	return b.b, nil
+}
+
+// Close ends the listing.
+func (d *dir) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.closeIter()
+}
+
+// statIterator is a DirIterator over the result of Children.
+type statIterator struct {
+	stats []Stat
+}
+
+func (it *statIterator) Next(ctx context.Context, n int) ([]Stat, error) {
+	if len(it.stats) == 0 {
+		return nil, io.EOF
+	}
+
+	cs := it.stats
+	it.stats = nil
+	return cs, io.EOF
+}
@@ -0,0 +1,644 @@
+package np
+
+import (
+	"time"
+)
+
+// 9P2000.L message types.
+//
+// https://github.com/chaos/diod/blob/master/protocol.md
+const (
+	msgRlerror   = 7
+	msgTstatfs   = 8
+	msgRstatfs   = 9
+	msgTlopen    = 12
+	msgRlopen    = 13
+	msgTlcreate  = 14
+	msgRlcreate  = 15
+	msgTgetattr  = 24
+	msgRgetattr  = 25
+	msgTsetattr  = 26
+	msgRsetattr  = 27
+	msgTreaddir  = 40
+	msgRreaddir  = 41
+	msgTfsync    = 50
+	msgRfsync    = 51
+	msgTmkdir    = 72
+	msgRmkdir    = 73
+	msgTrenameat = 74
+	msgRrenameat = 75
+	msgTunlinkat = 76
+	msgRunlinkat = 77
+
+	msgTauth   = 102
+	msgTattach = 104
+)
+
+func newLMsg(typ uint8) wireMsg { //nolint:ireturn,cyclop
+	switch typ {
+	case msgTstatfs:
+		return &tstatfs{}
+	case msgTlopen:
+		return &tlopen{}
+	case msgTlcreate:
+		return &tlcreate{}
+	case msgTgetattr:
+		return &tgetattr{}
+	case msgTsetattr:
+		return &tsetattr{}
+	case msgTreaddir:
+		return &treaddir{}
+	case msgTfsync:
+		return &tfsync{}
+	case msgTmkdir:
+		return &tmkdir{}
+	case msgTrenameat:
+		return &trenameat{}
+	case msgTunlinkat:
+		return &tunlinkat{}
+	case msgTauth:
+		return &tauthu{}
+	case msgTattach:
+		return &tattachu{}
+	}
+	return nil
+}
+
+// Linux open(2) flags, as used by Tlopen and Tlcreate.
+const (
+	lOWronly = 0o1
+	lORdwr   = 0o2
+	lOTrunc  = 0o1000
+	lOAccess = 0o3
+)
+
+// lopenMode converts Linux open(2) flags to an OpenMode.
+func lopenMode(flags uint32) OpenMode {
+	var mode OpenMode
+	switch flags & lOAccess {
+	case lOWronly:
+		mode = OWrite
+	case lORdwr:
+		mode = ORdwr
+	default:
+		mode = ORead
+	}
+	if flags&lOTrunc != 0 {
+		mode |= OTrunc
+	}
+	return mode
+}
+
+// Linux stat(2) mode bits.
+const (
+	sIFMT   = 0o170000
+	sIFSOCK = 0o140000
+	sIFLNK  = 0o120000
+	sIFREG  = 0o100000
+	sIFBLK  = 0o060000
+	sIFDIR  = 0o040000
+	sIFCHR  = 0o020000
+	sIFIFO  = 0o010000
+	sISUID  = 0o4000
+	sISGID  = 0o2000
+	sISVTX  = 0o1000
+)
+
+// unixMode converts a 9P Mode to a Linux stat(2) mode.
+func unixMode(m Mode) uint32 {
+	um := uint32(m & ModePerm)
+	switch {
+	case m&ModeDir != 0:
+		um |= sIFDIR
+	case m&ModeSymlink != 0:
+		um |= sIFLNK
+	case m&ModeSocket != 0:
+		um |= sIFSOCK
+	case m&ModeNamedPipe != 0:
+		um |= sIFIFO
+	case m&ModeDevice != 0:
+		um |= sIFCHR
+	default:
+		um |= sIFREG
+	}
+	if m&ModeSetuid != 0 {
+		um |= sISUID
+	}
+	if m&ModeSetgid != 0 {
+		um |= sISGID
+	}
+	return um
+}
+
+// fromUnixMode converts a Linux stat(2) mode to a 9P Mode.
+func fromUnixMode(um uint32) Mode {
+	m := Mode(um & uint32(ModePerm))
+	switch um & sIFMT {
+	case sIFDIR:
+		m |= ModeDir
+	case sIFLNK:
+		m |= ModeSymlink
+	case sIFSOCK:
+		m |= ModeSocket
+	case sIFIFO:
+		m |= ModeNamedPipe
+	case sIFCHR, sIFBLK:
+		m |= ModeDevice
+	}
+	if um&sISUID != 0 {
+		m |= ModeSetuid
+	}
+	if um&sISGID != 0 {
+		m |= ModeSetgid
+	}
+	return m
+}
+
+// direntType returns the dirent(3) d_type for m.
+func direntType(m Mode) uint8 {
+	const (
+		dtFIFO = 1
+		dtCHR  = 2
+		dtDIR  = 4
+		dtREG  = 8
+		dtLNK  = 10
+		dtSOCK = 12
+	)
+
+	switch {
+	case m&ModeDir != 0:
+		return dtDIR
+	case m&ModeSymlink != 0:
+		return dtLNK
+	case m&ModeSocket != 0:
+		return dtSOCK
+	case m&ModeNamedPipe != 0:
+		return dtFIFO
+	case m&ModeDevice != 0:
+		return dtCHR
+	}
+	return dtREG
+}
+
+type rlerror struct {
+	Ecode uint32
+}
+
+func (m *rlerror) msgType() uint8 { return msgRlerror }
+func (m *rlerror) encode(b *wbuf) { b.u32(m.Ecode) }
+func (m *rlerror) decode(b *rbuf) { m.Ecode = b.u32() }
+
+type tstatfs struct {
+	Fid uint32
+}
+
+func (m *tstatfs) msgType() uint8 { return msgTstatfs }
+func (m *tstatfs) encode(b *wbuf) { b.u32(m.Fid) }
+func (m *tstatfs) decode(b *rbuf) { m.Fid = b.u32() }
+
+type rstatfs struct {
+	FSStat
+}
+
+func (m *rstatfs) msgType() uint8 { return msgRstatfs }
+
+func (m *rstatfs) encode(b *wbuf) {
+	b.u32(m.Type)
+	b.u32(m.Bsize)
+	b.u64(m.Blocks)
+	b.u64(m.Bfree)
+	b.u64(m.Bavail)
+	b.u64(m.Files)
+	b.u64(m.Ffree)
+	b.u64(m.Fsid)
+	b.u32(m.Namelen)
+}
+
+func (m *rstatfs) decode(b *rbuf) {
+	m.Type = b.u32()
+	m.Bsize = b.u32()
+	m.Blocks = b.u64()
+	m.Bfree = b.u64()
+	m.Bavail = b.u64()
+	m.Files = b.u64()
+	m.Ffree = b.u64()
+	m.Fsid = b.u64()
+	m.Namelen = b.u32()
+}
+
+type tlopen struct {
+	Fid   uint32
+	Flags uint32
+}
+
+func (m *tlopen) msgType() uint8 { return msgTlopen }
+
+func (m *tlopen) encode(b *wbuf) {
+	b.u32(m.Fid)
+	b.u32(m.Flags)
+}
+
+func (m *tlopen) decode(b *rbuf) {
+	m.Fid = b.u32()
+	m.Flags = b.u32()
+}
+
+type rlopen struct {
+	Qid    Qid
+	Iounit uint32
+}
+
+func (m *rlopen) msgType() uint8 { return msgRlopen }
+
+func (m *rlopen) encode(b *wbuf) {
+	b.qid(m.Qid)
+	b.u32(m.Iounit)
+}
+
+func (m *rlopen) decode(b *rbuf) {
+	m.Qid = b.qid()
+	m.Iounit = b.u32()
+}
+
+type tlcreate struct {
+	Fid   uint32
+	Name  string
+	Flags uint32
+	Mode  uint32
+	Gid   uint32
+}
+
+func (m *tlcreate) msgType() uint8 { return msgTlcreate }
+
+func (m *tlcreate) encode(b *wbuf) {
+	b.u32(m.Fid)
+	b.str(m.Name)
+	b.u32(m.Flags)
+	b.u32(m.Mode)
+	b.u32(m.Gid)
+}
+
+func (m *tlcreate) decode(b *rbuf) {
+	m.Fid = b.u32()
+	m.Name = b.str()
+	m.Flags = b.u32()
+	m.Mode = b.u32()
+	m.Gid = b.u32()
+}
+
+type rlcreate struct {
+	rlopen
+}
+
+func (m *rlcreate) msgType() uint8 { return msgRlcreate }
+
+type tgetattr struct {
+	Fid  uint32
+	Mask uint64
+}
+
+func (m *tgetattr) msgType() uint8 { return msgTgetattr }
+
+func (m *tgetattr) encode(b *wbuf) {
+	b.u32(m.Fid)
+	b.u64(m.Mask)
+}
+
+func (m *tgetattr) decode(b *rbuf) {
+	m.Fid = b.u32()
+	m.Mask = b.u64()
+}
+
+// Tgetattr request_mask and Rgetattr valid bits.
+const (
+	getattrBasic = 0x000007ff
+)
+
+type rgetattr struct {
+	Valid       uint64
+	Qid         Qid
+	Mode        uint32
+	Uid         uint32
+	Gid         uint32
+	Nlink       uint64
+	Rdev        uint64
+	Size        uint64
+	Blksize     uint64
+	Blocks      uint64
+	Atime       time.Time
+	Mtime       time.Time
+	Ctime       time.Time
+	Btime       time.Time
+	Gen         uint64
+	DataVersion uint64
+}
+
+func (m *rgetattr) msgType() uint8 { return msgRgetattr }
+
+func (m *rgetattr) encode(b *wbuf) {
+	b.u64(m.Valid)
+	b.qid(m.Qid)
+	b.u32(m.Mode)
+	b.u32(m.Uid)
+	b.u32(m.Gid)
+	b.u64(m.Nlink)
+	b.u64(m.Rdev)
+	b.u64(m.Size)
+	b.u64(m.Blksize)
+	b.u64(m.Blocks)
+	b.time(m.Atime)
+	b.time(m.Mtime)
+	b.time(m.Ctime)
+	b.time(m.Btime)
+	b.u64(m.Gen)
+	b.u64(m.DataVersion)
+}
+
+func (m *rgetattr) decode(b *rbuf) {
+	m.Valid = b.u64()
+	m.Qid = b.qid()
+	m.Mode = b.u32()
+	m.Uid = b.u32()
+	m.Gid = b.u32()
+	m.Nlink = b.u64()
+	m.Rdev = b.u64()
+	m.Size = b.u64()
+	m.Blksize = b.u64()
+	m.Blocks = b.u64()
+	m.Atime = b.time()
+	m.Mtime = b.time()
+	m.Ctime = b.time()
+	m.Btime = b.time()
+	m.Gen = b.u64()
+	m.DataVersion = b.u64()
+}
+
+// Tsetattr valid bits.
+const (
+	setattrMode     = 0x001
+	setattrUid      = 0x002
+	setattrGid      = 0x004
+	setattrSize     = 0x008
+	setattrAtime    = 0x010
+	setattrMtime    = 0x020
+	setattrAtimeSet = 0x080
+	setattrMtimeSet = 0x100
+)
+
+type tsetattr struct {
+	Fid   uint32
+	Valid uint32
+	Mode  uint32
+	Uid   uint32
+	Gid   uint32
+	Size  uint64
+	Atime time.Time
+	Mtime time.Time
+}
+
+func (m *tsetattr) msgType() uint8 { return msgTsetattr }
+
+func (m *tsetattr) encode(b *wbuf) {
+	b.u32(m.Fid)
+	b.u32(m.Valid)
+	b.u32(m.Mode)
+	b.u32(m.Uid)
+	b.u32(m.Gid)
+	b.u64(m.Size)
+	b.time(m.Atime)
+	b.time(m.Mtime)
+}
+
+func (m *tsetattr) decode(b *rbuf) {
+	m.Fid = b.u32()
+	m.Valid = b.u32()
+	m.Mode = b.u32()
+	m.Uid = b.u32()
+	m.Gid = b.u32()
+	m.Size = b.u64()
+	m.Atime = b.time()
+	m.Mtime = b.time()
+}
+
+type rsetattr struct{}
+
+func (m *rsetattr) msgType() uint8 { return msgRsetattr }
+func (m *rsetattr) encode(b *wbuf) {}
+func (m *rsetattr) decode(b *rbuf) {}
+
+type treaddir struct {
+	Fid    uint32
+	Offset uint64
+	Count  uint32
+}
+
+func (m *treaddir) msgType() uint8 { return msgTreaddir }
+
+func (m *treaddir) encode(b *wbuf) {
+	b.u32(m.Fid)
+	b.u64(m.Offset)
+	b.u32(m.Count)
+}
+
+func (m *treaddir) decode(b *rbuf) {
+	m.Fid = b.u32()
+	m.Offset = b.u64()
+	m.Count = b.u32()
+}
+
+type rreaddir struct {
+	Data []byte
+}
+
+func (m *rreaddir) msgType() uint8 { return msgRreaddir }
+
+func (m *rreaddir) encode(b *wbuf) {
+	b.u32(uint32(len(m.Data)))
+	b.data(m.Data)
+}
+
+func (m *rreaddir) decode(b *rbuf) {
+	m.Data = b.next(int(b.u32()))
+}
+
+// direntSize returns the encoded size of a Rreaddir entry.
+func direntSize(name string) int {
+	return 13 + 8 + 1 + 2 + len(name)
+}
+
+// dirent encodes a Rreaddir entry.
+func (w *wbuf) dirent(q Qid, offset uint64, typ uint8, name string) {
+	w.qid(q)
+	w.u64(offset)
+	w.u8(typ)
+	w.str(name)
+}
+
+type tfsync struct {
+	Fid      uint32
+	Datasync uint32
+}
+
+func (m *tfsync) msgType() uint8 { return msgTfsync }
+
+func (m *tfsync) encode(b *wbuf) {
+	b.u32(m.Fid)
+	b.u32(m.Datasync)
+}
+
+func (m *tfsync) decode(b *rbuf) {
+	m.Fid = b.u32()
+	// older kernels don't send datasync
+	if b.len() >= 4 {
+		m.Datasync = b.u32()
+	}
+}
+
+type rfsync struct{}
+
+func (m *rfsync) msgType() uint8 { return msgRfsync }
+func (m *rfsync) encode(b *wbuf) {}
+func (m *rfsync) decode(b *rbuf) {}
+
+type tmkdir struct {
+	Dfid uint32
+	Name string
+	Mode uint32
+	Gid  uint32
+}
+
+func (m *tmkdir) msgType() uint8 { return msgTmkdir }
+
+func (m *tmkdir) encode(b *wbuf) {
+	b.u32(m.Dfid)
+	b.str(m.Name)
+	b.u32(m.Mode)
+	b.u32(m.Gid)
+}
+
+func (m *tmkdir) decode(b *rbuf) {
+	m.Dfid = b.u32()
+	m.Name = b.str()
+	m.Mode = b.u32()
+	m.Gid = b.u32()
+}
+
+type rmkdir struct {
+	Qid Qid
+}
+
+func (m *rmkdir) msgType() uint8 { return msgRmkdir }
+func (m *rmkdir) encode(b *wbuf) { b.qid(m.Qid) }
+func (m *rmkdir) decode(b *rbuf) { m.Qid = b.qid() }
+
+type trenameat struct {
+	Olddfid uint32
+	Oldname string
+	Newdfid uint32
+	Newname string
+}
+
+func (m *trenameat) msgType() uint8 { return msgTrenameat }
+
+func (m *trenameat) encode(b *wbuf) {
+	b.u32(m.Olddfid)
+	b.str(m.Oldname)
+	b.u32(m.Newdfid)
+	b.str(m.Newname)
+}
+
+func (m *trenameat) decode(b *rbuf) {
+	m.Olddfid = b.u32()
+	m.Oldname = b.str()
+	m.Newdfid = b.u32()
+	m.Newname = b.str()
+}
+
+type rrenameat struct{}
+
+func (m *rrenameat) msgType() uint8 { return msgRrenameat }
+func (m *rrenameat) encode(b *wbuf) {}
+func (m *rrenameat) decode(b *rbuf) {}
+
+// Tunlinkat flags.
+const atRemovedir = 0x200
+
+type tunlinkat struct {
+	Dfid  uint32
+	Name  string
+	Flags uint32
+}
+
+func (m *tunlinkat) msgType() uint8 { return msgTunlinkat }
+
+func (m *tunlinkat) encode(b *wbuf) {
+	b.u32(m.Dfid)
+	b.str(m.Name)
+	b.u32(m.Flags)
+}
+
+func (m *tunlinkat) decode(b *rbuf) {
+	m.Dfid = b.u32()
+	m.Name = b.str()
+	m.Flags = b.u32()
+}
+
+type runlinkat struct{}
+
+func (m *runlinkat) msgType() uint8 { return msgRunlinkat }
+func (m *runlinkat) encode(b *wbuf) {}
+func (m *runlinkat) decode(b *rbuf) {}
+
+// noUid is used by 9P2000.u and 9P2000.L for unset numeric ids.
+const noUid = ^uint32(0)
+
+// tauthu is a Tauth with the n_uname field used by 9P2000.u and 9P2000.L.
+type tauthu struct {
+	Afid   uint32
+	Uname  string
+	Aname  string
+	Nuname uint32
+}
+
+func (m *tauthu) msgType() uint8 { return msgTauth }
+
+func (m *tauthu) encode(b *wbuf) {
+	b.u32(m.Afid)
+	b.str(m.Uname)
+	b.str(m.Aname)
+	b.u32(m.Nuname)
+}
+
+func (m *tauthu) decode(b *rbuf) {
+	m.Afid = b.u32()
+	m.Uname = b.str()
+	m.Aname = b.str()
+	m.Nuname = b.u32()
+}
+
+// tattachu is a Tattach with the n_uname field used by 9P2000.u and 9P2000.L.
+type tattachu struct {
+	Fid    uint32
+	Afid   uint32
+	Uname  string
+	Aname  string
+	Nuname uint32
+}
+
+func (m *tattachu) msgType() uint8 { return msgTattach }
+
+func (m *tattachu) encode(b *wbuf) {
+	b.u32(m.Fid)
+	b.u32(m.Afid)
+	b.str(m.Uname)
+	b.str(m.Aname)
+	b.u32(m.Nuname)
+}
+
+func (m *tattachu) decode(b *rbuf) {
+	m.Fid = b.u32()
+	m.Afid = b.u32()
+	m.Uname = b.str()
+	m.Aname = b.str()
+	m.Nuname = b.u32()
+}
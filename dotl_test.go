@@ -0,0 +1,305 @@
+package np
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.rbn.im/neinp/message"
+)
+
+// memFile is an in-memory file.
+type memFile struct {
+	mu *sync.Mutex
+	st Stat
+}
+
+func (f *memFile) Stat() (Stat, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.st, nil
+}
+
+func (f *memFile) Wstat(sc StatChanges) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if sc.Has(StatMode) {
+		f.st.Mode = sc.Mode
+	}
+	return nil
+}
+
+// memDir is an in-memory directory, which is opened by an Opener.
+type memDir struct {
+	*memFile
+	children map[string]Node
+}
+
+func newMemDir(mu *sync.Mutex, name string, perm Mode) *memDir {
+	return &memDir{
+		memFile:  &memFile{mu: mu, st: Stat{Name: name, Mode: ModeDir | perm, Uid: "glenda", Qid: Qid{Type: QTDir}}},
+		children: map[string]Node{},
+	}
+}
+
+func (d *memDir) Open(mode OpenMode) (any, uint32, error) {
+	return struct{}{}, 0, nil
+}
+
+func (d *memDir) Children() ([]Stat, error) {
+	d.mu.Lock()
+	names := make([]string, 0, len(d.children))
+	for name := range d.children {
+		names = append(names, name)
+	}
+	d.mu.Unlock()
+	sort.Strings(names)
+
+	sts := make([]Stat, 0, len(names))
+	for _, name := range names {
+		if c, err := d.Walk(name); err == nil {
+			st, _ := c.Stat()
+			sts = append(sts, st)
+		}
+	}
+	return sts, nil
+}
+
+func (d *memDir) Walk(name string) (Node, error) { //nolint:ireturn
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	c, ok := d.children[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return c, nil
+}
+
+func (d *memDir) Create(name string, perm Mode, mode OpenMode) (Node, error) { //nolint:ireturn
+	var c Node
+	if perm&ModeDir != 0 {
+		c = newMemDir(d.mu, name, perm&ModePerm)
+	} else {
+		c = &memFile{mu: d.mu, st: Stat{Name: name, Mode: perm, Uid: "glenda", Qid: Qid{Type: QTFile}}}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.children[name]; ok {
+		return nil, ErrExists
+	}
+	d.children[name] = c
+	return c, nil
+}
+
+func (d *memDir) RemoveChild(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.children, name)
+	return nil
+}
+
+// lclient is a minimal 9P2000.L client.
+type lclient struct {
+	t  *testing.T
+	rw net.Conn
+}
+
+func newLClient(t *testing.T, root Node) *lclient {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sc, cc := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = Serve(ctx, sc, root)
+	}()
+	t.Cleanup(func() {
+		cc.Close()
+		cancel()
+		<-done
+	})
+
+	lc := &lclient{t: t, rw: cc}
+	rv, err := lc.call(&message.TVersion{Msize: 8192, Version: "9P2000.L"}, nil)
+	require.Nil(t, err)
+	require.Equal(t, "9P2000.L", rv.(*message.RVersion).Version) //nolint:forcetypeassert
+	return lc
+}
+
+// call sends req and returns its response, which is decoded into res if it's
+// a 9P2000.L message. Rlerror responses are returned as an Error with only
+// the errno set.
+func (lc *lclient) call(req any, res wireMsg) (any, error) {
+	lc.t.Helper()
+
+	require.Nil(lc.t, writeMsg(lc.rw, fcall{Tag: 1, Content: req}))
+
+	var hdr [4]byte
+	_, err := io.ReadFull(lc.rw, hdr[:])
+	require.Nil(lc.t, err)
+	buf := make([]byte, binary.LittleEndian.Uint32(hdr[:]))
+	copy(buf, hdr[:])
+	_, err = io.ReadFull(lc.rw, buf[len(hdr):])
+	require.Nil(lc.t, err)
+
+	switch {
+	case buf[4] == msgRlerror:
+		var rl rlerror
+		rl.decode(&rbuf{b: buf[headerSize:]})
+		return nil, Error{errno: rl.Ecode}
+	case res != nil:
+		require.Equal(lc.t, res.msgType(), buf[4])
+		rb := &rbuf{b: buf[headerSize:]}
+		res.decode(rb)
+		require.Nil(lc.t, rb.err)
+		return res, nil
+	}
+
+	var m message.Message
+	_, err = m.Decode(bytes.NewReader(buf))
+	require.Nil(lc.t, err)
+	return m.Content, nil
+}
+
+// getattr returns the mode of fid.
+func (lc *lclient) mode(fid uint32) uint32 {
+	lc.t.Helper()
+
+	var r rgetattr
+	_, err := lc.call(&tgetattr{Fid: fid, Mask: getattrBasic}, &r)
+	require.Nil(lc.t, err)
+	return r.Mode
+}
+
+// readdir returns the names in the directory fid.
+func (lc *lclient) readdir(fid uint32) []string {
+	lc.t.Helper()
+
+	var names []string
+	off := uint64(0)
+	for {
+		var r rreaddir
+		_, err := lc.call(&treaddir{Fid: fid, Offset: off, Count: 64}, &r)
+		require.Nil(lc.t, err)
+		if len(r.Data) == 0 {
+			return names
+		}
+
+		rb := &rbuf{b: r.Data}
+		for rb.len() > 0 {
+			rb.qid()
+			off = rb.u64()
+			rb.u8()
+			names = append(names, rb.str())
+		}
+		require.Nil(lc.t, rb.err)
+	}
+}
+
+func TestDotLReaddirRewind(t *testing.T) {
+	t.Parallel()
+
+	root := newMemDir(&sync.Mutex{}, "/", 0o777)
+	for _, name := range []string{"a", "b", "c"} {
+		_, err := root.Create(name, 0o666, OWrite)
+		require.Nil(t, err)
+	}
+
+	lc := newLClient(t, root)
+	_, err := lc.call(&tattachu{Fid: 0, Afid: ^uint32(0), Uname: "glenda", Nuname: noUid}, nil)
+	require.Nil(t, err)
+	_, err = lc.call(&tlopen{Fid: 0}, &rlopen{})
+	require.Nil(t, err)
+
+	// reads one entry at offset
+	read := func(offset uint64) string {
+		t.Helper()
+
+		var r rreaddir
+		_, err := lc.call(&treaddir{Fid: 0, Offset: offset, Count: uint32(direntSize("a"))}, &r)
+		require.Nil(t, err)
+		rb := &rbuf{b: r.Data}
+		rb.qid()
+		require.Equal(t, offset+1, rb.u64())
+		rb.u8()
+		return rb.str()
+	}
+
+	require.Equal(t, "a", read(0))
+	require.Equal(t, "b", read(1))
+	require.Equal(t, "c", read(2))
+
+	// earlier offsets can be read again
+	require.Equal(t, "b", read(1))
+	require.Equal(t, "c", read(2))
+}
+
+func TestDotL(t *testing.T) {
+	t.Parallel()
+
+	root := newMemDir(&sync.Mutex{}, "/", 0o750)
+	lc := newLClient(t, root)
+
+	// attach
+	ra, err := lc.call(&tattachu{Fid: 0, Afid: ^uint32(0), Uname: "glenda", Nuname: noUid}, nil)
+	require.Nil(t, err)
+	require.Equal(t, QTDir, ra.(*message.RAttach).Qid.Type) //nolint:forcetypeassert
+	require.Equal(t, uint32(sIFDIR|0o750), lc.mode(0))
+
+	// permissions of new files are limited by the directory
+	var rm rmkdir
+	_, err = lc.call(&tmkdir{Dfid: 0, Name: "d", Mode: 0o777}, &rm)
+	require.Nil(t, err)
+	require.Equal(t, QTDir, rm.Qid.Type)
+
+	_, err = lc.call(&message.TWalk{Fid: 0, Newfid: 1, Wname: []string{"d"}}, nil)
+	require.Nil(t, err)
+	require.Equal(t, uint32(sIFDIR|0o750), lc.mode(1))
+
+	_, err = lc.call(&message.TWalk{Fid: 0, Newfid: 2}, nil)
+	require.Nil(t, err)
+	_, err = lc.call(&tlcreate{Fid: 2, Name: "f", Flags: lORdwr, Mode: sIFREG | 0o666}, &rlcreate{})
+	require.Nil(t, err)
+	require.Equal(t, uint32(sIFREG|0o640), lc.mode(2))
+
+	// setattr changes the permissions, not the type
+	_, err = lc.call(&tsetattr{Fid: 1, Valid: setattrMode, Mode: 0o700}, &rsetattr{})
+	require.Nil(t, err)
+	require.Equal(t, uint32(sIFDIR|0o700), lc.mode(1))
+	_, err = lc.call(&tsetattr{Fid: 2, Valid: setattrMode, Mode: sIFREG | 0o600}, &rsetattr{})
+	require.Nil(t, err)
+	require.Equal(t, uint32(sIFREG|0o600), lc.mode(2))
+
+	// readdir of a directory opened by an Opener
+	_, err = lc.call(&message.TWalk{Fid: 0, Newfid: 3}, nil)
+	require.Nil(t, err)
+	_, err = lc.call(&tlopen{Fid: 3}, &rlopen{})
+	require.Nil(t, err)
+	require.Equal(t, []string{"d", "f"}, lc.readdir(3))
+
+	// entries are not split
+	_, err = lc.call(&treaddir{Fid: 3, Count: 8}, &rreaddir{})
+	require.Equal(t, Error{errno: ErrBadCount.errno}, err)
+
+	// readdir needs an opened directory
+	_, err = lc.call(&treaddir{Fid: 1, Count: 64}, &rreaddir{})
+	require.Equal(t, Error{errno: ErrBadFid.errno}, err)
+
+	// unlinkat
+	_, err = lc.call(&tunlinkat{Dfid: 0, Name: "f"}, &runlinkat{})
+	require.Nil(t, err)
+	_, err = lc.call(&tunlinkat{Dfid: 0, Name: "d"}, &runlinkat{})
+	require.Error(t, err)
+	_, err = lc.call(&tunlinkat{Dfid: 0, Name: "d", Flags: atRemovedir}, &runlinkat{})
+	require.Nil(t, err)
+	require.Empty(t, lc.readdir(3))
+}
@@ -0,0 +1,250 @@
+package np
+
+import (
+	"strconv"
+	"time"
+)
+
+// 9P2000 message types that are changed by 9P2000.u.
+//
+// http://ericvh.github.io/9p-rfc/rfc9p2000.u.html
+const (
+	msgRerror  = 107
+	msgTcreate = 114
+	msgRstat   = 125
+	msgTwstat  = 126
+)
+
+func newUMsg(typ uint8) wireMsg { //nolint:ireturn
+	switch typ {
+	case msgTauth:
+		return &tauthu{}
+	case msgTattach:
+		return &tattachu{}
+	case msgTcreate:
+		return &tcreateu{}
+	case msgTwstat:
+		return &twstatu{}
+	}
+	return nil
+}
+
+// UnixStat contains the 9P2000.u extensions to Stat.
+type UnixStat struct {
+	// Extension describes special files:
+	//   - symbolic links: the target of the link
+	//   - devices: "b major minor" or "c major minor"
+	//   - hard links: the fid of the link target, in a create
+	Extension string
+
+	Uid  uint32
+	Gid  uint32
+	Muid uint32
+}
+
+// UnixStater allows Nodes to provide 9P2000.u stat fields.
+//
+// Nodes that do not implement UnixStater use numeric Stat owners as ids.
+type UnixStater interface {
+	UnixStat() (UnixStat, error)
+}
+
+// unixStat returns the 9P2000.u fields for st.
+func unixStat(st *Stat) UnixStat {
+	return UnixStat{
+		Uid:  numericID(st.Uid),
+		Gid:  numericID(st.Gid),
+		Muid: numericID(st.Muid),
+	}
+}
+
+// nodeUnixStat returns the 9P2000.u fields for node, which has st as its stat.
+func nodeUnixStat(node Node, st *Stat) (UnixStat, error) {
+	if us, ok := UnwrapValue[UnixStater](node); ok {
+		return us.UnixStat() //nolint:wrapcheck
+	}
+	return unixStat(st), nil
+}
+
+// numericID parses a numeric user or group id.
+func numericID(id string) uint32 {
+	n, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return noUid
+	}
+	return uint32(n)
+}
+
+// stat encodes st, with 9P2000.u fields if us is not nil.
+func (w *wbuf) stat(st *Stat, us *UnixStat) {
+	start := len(w.b)
+	w.u16(0)
+	w.u16(st.Typ)
+	w.u32(st.Dev)
+	w.qid(st.Qid)
+	w.u32(uint32(st.Mode))
+	w.u32(unixTime(st.Atime))
+	w.u32(unixTime(st.Mtime))
+	w.u64(st.Length)
+	w.str(st.Name)
+	w.str(st.Uid)
+	w.str(st.Gid)
+	w.str(st.Muid)
+	if us != nil {
+		w.str(us.Extension)
+		w.u32(us.Uid)
+		w.u32(us.Gid)
+		w.u32(us.Muid)
+	}
+
+	size := len(w.b) - start - 2
+	w.b[start] = byte(size)
+	w.b[start+1] = byte(size >> 8)
+}
+
+// stat decodes a stat, with 9P2000.u fields if dotu is true.
+func (r *rbuf) stat(dotu bool) (Stat, UnixStat) {
+	var st Stat
+	var us UnixStat
+
+	sr := &rbuf{b: r.next(int(r.u16()))}
+	st.Typ = sr.u16()
+	st.Dev = sr.u32()
+	st.Qid = sr.qid()
+	st.Mode = Mode(sr.u32())
+	st.Atime = fromUnixTime(sr.u32())
+	st.Mtime = fromUnixTime(sr.u32())
+	st.Length = sr.u64()
+	st.Name = sr.str()
+	st.Uid = sr.str()
+	st.Gid = sr.str()
+	st.Muid = sr.str()
+	if dotu {
+		us.Extension = sr.str()
+		us.Uid = sr.u32()
+		us.Gid = sr.u32()
+		us.Muid = sr.u32()
+	}
+
+	if r.err == nil {
+		r.err = sr.err
+	}
+	return st, us
+}
+
+func unixTime(t time.Time) uint32 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint32(t.Unix())
+}
+
+func fromUnixTime(t uint32) time.Time {
+	return time.Unix(int64(t), 0)
+}
+
+// rerroru is a Rerror with the errno field added by 9P2000.u.
+type rerroru struct {
+	Ename string
+	Errno uint32
+}
+
+func (m *rerroru) msgType() uint8 { return msgRerror }
+
+func (m *rerroru) encode(b *wbuf) {
+	b.str(m.Ename)
+	b.u32(m.Errno)
+}
+
+func (m *rerroru) decode(b *rbuf) {
+	m.Ename = b.str()
+	m.Errno = b.u32()
+}
+
+// tcreateu is a Tcreate with the extension field added by 9P2000.u.
+type tcreateu struct {
+	Fid       uint32
+	Name      string
+	Perm      Mode
+	Mode      OpenMode
+	Extension string
+}
+
+func (m *tcreateu) msgType() uint8 { return msgTcreate }
+
+func (m *tcreateu) encode(b *wbuf) {
+	b.u32(m.Fid)
+	b.str(m.Name)
+	b.u32(uint32(m.Perm))
+	b.u8(uint8(m.Mode))
+	b.str(m.Extension)
+}
+
+func (m *tcreateu) decode(b *rbuf) {
+	m.Fid = b.u32()
+	m.Name = b.str()
+	m.Perm = Mode(b.u32())
+	m.Mode = OpenMode(b.u8())
+	m.Extension = b.str()
+}
+
+// rstatu is a Rstat with a 9P2000.u stat.
+type rstatu struct {
+	Stat     Stat
+	UnixStat UnixStat
+}
+
+func (m *rstatu) msgType() uint8 { return msgRstat }
+
+func (m *rstatu) encode(b *wbuf) {
+	// stat[n] is prefixed by its size, on top of the size in the stat
+	start := len(b.b)
+	b.u16(0)
+	b.stat(&m.Stat, &m.UnixStat)
+	size := len(b.b) - start - 2
+	b.b[start] = byte(size)
+	b.b[start+1] = byte(size >> 8)
+}
+
+func (m *rstatu) decode(b *rbuf) {
+	b.u16()
+	m.Stat, m.UnixStat = b.stat(true)
+}
+
+// twstatu is a Twstat with a 9P2000.u stat.
+type twstatu struct {
+	Fid      uint32
+	Stat     Stat
+	UnixStat UnixStat
+}
+
+func (m *twstatu) msgType() uint8 { return msgTwstat }
+
+func (m *twstatu) encode(b *wbuf) {
+	b.u32(m.Fid)
+	start := len(b.b)
+	b.u16(0)
+	b.stat(&m.Stat, &m.UnixStat)
+	size := len(b.b) - start - 2
+	b.b[start] = byte(size)
+	b.b[start+1] = byte(size >> 8)
+}
+
+func (m *twstatu) decode(b *rbuf) {
+	m.Fid = b.u32()
+	b.u16()
+	m.Stat, m.UnixStat = b.stat(true)
+}
+
+// wstatStat returns the Stat of a 9P2000.u Twstat, using numeric ids when
+// the string ids are not set.
+func (m *twstatu) wstatStat() Stat {
+	st := m.Stat
+	if st.Uid == "" && m.UnixStat.Uid != noUid {
+		st.Uid = strconv.FormatUint(uint64(m.UnixStat.Uid), 10)
+	}
+	if st.Gid == "" && m.UnixStat.Gid != noUid {
+		st.Gid = strconv.FormatUint(uint64(m.UnixStat.Gid), 10)
+	}
+	return st
+}
@@ -1,127 +1,251 @@
 package np
 
+// Error is an error that is sent to clients as is.
+//
+// Errors that are not Error are sent as ErrIO.
 type Error struct {
-	err string
+	err   string
+	errno uint32
 }
 
 func (e Error) Error() string { return e.err }
 
+// Errno returns the Linux errno for e, as used by 9P2000.u and 9P2000.L.
+func (e Error) Errno() uint32 { return e.errno }
+
+// Linux errno values.
+const (
+	eperm           = 1
+	enoent          = 2
+	eintr           = 4
+	eio             = 5
+	enxio           = 6
+	e2big           = 7
+	ebadf           = 9
+	eagain          = 11
+	enomem          = 12
+	eacces          = 13
+	efault          = 14
+	enotblk         = 15
+	ebusy           = 16
+	eexist          = 17
+	exdev           = 18
+	enodev          = 19
+	enotdir         = 20
+	eisdir          = 21
+	einval          = 22
+	enfile          = 23
+	emfile          = 24
+	etxtbsy         = 26
+	efbig           = 27
+	enospc          = 28
+	espipe          = 29
+	erofs           = 30
+	emlink          = 31
+	epipe           = 32
+	edom            = 33
+	erange          = 34
+	edeadlk         = 35
+	enametoolong    = 36
+	enolck          = 37
+	enosys          = 38
+	enotempty       = 39
+	eloop           = 40
+	enomsg          = 42
+	eidrm           = 43
+	enodata         = 61
+	enonet          = 64
+	enopkg          = 65
+	eremote         = 66
+	enolink         = 67
+	ecomm           = 70
+	eproto          = 71
+	ebadmsg         = 74
+	ebadfd          = 77
+	estrpipe        = 86
+	eusers          = 87
+	enotsock        = 88
+	emsgsize        = 90
+	enoprotoopt     = 92
+	eprotonosupport = 93
+	esocktnosupport = 94
+	eopnotsupp      = 95
+	epfnosupport    = 96
+	enetdown        = 100
+	enetunreach     = 101
+	enetreset       = 102
+	econnaborted    = 103
+	econnreset      = 104
+	enobufs         = 105
+	eisconn         = 106
+	enotconn        = 107
+	eshutdown       = 108
+	etimedout       = 110
+	econnrefused    = 111
+	ehostdown       = 112
+	ehostunreach    = 113
+	ealready        = 114
+	einprogress     = 115
+	eisnam          = 120
+	eremoteio       = 121
+	edquot          = 122
+)
+
 var (
 
 	// https://github.com/0intro/plan9/blob/7524062cfa4689019a4ed6fc22500ec209522ef0/sys/src/lib9p/srv.c#L10
-	ErrBadAttach    = Error{err: "unknown specifier in attach"}
-	ErrBadOffset    = Error{err: "bad offset"}
-	ErrBadCount     = Error{err: "bad count"}
-	ErrBotch        = Error{err: "9P protocol botch"} // EPROTO
-	ErrCreateNonDir = Error{err: "create in non-directory"}
-	ErrDupFid       = Error{err: "duplicate fid"}
-	ErrDupTag       = Error{err: "duplicate tag"}
-	ErrNoCreate     = Error{err: "create prohibited"}
-	ErrNoRemove     = Error{err: "remove prohibited"}
-	ErrNoStat       = Error{err: "stat prohibited"}
-	ErrNotFound     = Error{err: "file not found"} // ENOENT
-	ErrNoWrite      = Error{err: "write prohibited"}
-	ErrNoWstat      = Error{err: "wstat prohibited"}  // EPERM
-	ErrPerm         = Error{err: "permission denied"} // EACCES
-	ErrUnknownFid   = Error{err: "unknown fid"}
-	ErrBadDir       = Error{err: "bad directory in wstat"}
-	ErrWalkNoDir    = Error{err: "walk in non-directory"}
+	ErrBadAttach    = Error{err: "unknown specifier in attach", errno: einval}
+	ErrBadOffset    = Error{err: "bad offset", errno: einval}
+	ErrBadCount     = Error{err: "bad count", errno: einval}
+	ErrBotch        = Error{err: "9P protocol botch", errno: eproto}
+	ErrCreateNonDir = Error{err: "create in non-directory", errno: enotdir}
+	ErrDupFid       = Error{err: "duplicate fid", errno: ebadf}
+	ErrDupTag       = Error{err: "duplicate tag", errno: einval}
+	ErrNoAuth       = Error{err: "authentication not required", errno: einval}
+	ErrNoCreate     = Error{err: "create prohibited", errno: eperm}
+	ErrNoRemove     = Error{err: "remove prohibited", errno: eperm}
+	ErrNoStat       = Error{err: "stat prohibited", errno: eperm}
+	ErrNotFound     = Error{err: "file not found", errno: enoent}
+	ErrNoWrite      = Error{err: "write prohibited", errno: eperm}
+	ErrNoWstat      = Error{err: "wstat prohibited", errno: eperm}
+	ErrPerm         = Error{err: "permission denied", errno: eacces}
+	ErrUnknownFid   = Error{err: "unknown fid", errno: ebadf}
+	ErrBadDir       = Error{err: "bad directory in wstat", errno: einval}
+	ErrWalkNoDir    = Error{err: "walk in non-directory", errno: enotdir}
 
 	// https://github.com/torvalds/linux/blob/6e195b0f7c8e50927fa31946369c22a0534ec7e2/net/9p/error.c#L40
 
-	ErrBadFD    = Error{err: "File descriptor in bad state"} // EBADFD
-	ErrBadFid   = Error{err: "bad use of fid"}               // EBADF
-	ErrFidInUse = Error{err: "fid already in use"}           // EBADF
-
-	ErrAuth = Error{err: "authentication failed"} // ECONNREFUSED
-
-	ErrCrossDevice = Error{err: "Invalid cross-device link"} // EXDEV
-	ErrDeadlock    = Error{err: "Resource deadlock avoided"} // EDEADLK
-	ErrDirNotEmpty = Error{err: "directory is not empty"}    // ENOTEMPTY
-
-	ErrExists = Error{err: "file exists"}  // EEXIST
-	ErrInUse  = Error{err: "file in use"}  // ETXTBSY
-	ErrTooBig = Error{err: "file too big"} // EFBIG
-
-	ErrIllegalMode   = Error{err: "illegal mode"}   // EINVAL
-	ErrIllegalName   = Error{err: "illegal name"}   // ENAMETOOLONG
-	ErrIllegalOffset = Error{err: "illegal offset"} // EINVAL
-	ErrIllegalSeek   = Error{err: "Illegal seek"}   // ESPIPE
-
-	ErrInProgress  = Error{err: "Operation now in progress"} // EINPROGRESS
-	ErrInterrupted = Error{err: "Interrupted system call"}   // EINTR
-	ErrInvalidArg  = Error{err: "Invalid argument"}          // EINVAL
-	ErrIO          = Error{err: "i/o error"}                 // EIO
-
-	ErrBadMessage     = Error{err: "Bad message"}                // EBADMSG
-	ErrMessageTooLong = Error{err: "Message too long"}           // EMSGSIZE
-	ErrNoMessage      = Error{err: "No message of desired type"} // ENOMSG
-
-	ErrConnAbort      = Error{err: "Software caused connection abort"}        // ECONNABORTED
-	ErrConnected      = Error{err: "Transport endpoint is already connected"} // EISCONN
-	ErrConnRefused    = Error{err: "Connection refused"}                      // ECONNREFUSED
-	ErrConnReset      = Error{err: "Connection reset by peer"}                // ECONNRESET
-	ErrHostDown       = Error{err: "Host is down"}                            // EHOSTDOWN
-	ErrNetDown        = Error{err: "Network is down"}                         // ENETDOWN
-	ErrNetReset       = Error{err: "Network dropped connection on reset"}     // ENETRESET
-	ErrNetUnreachable = Error{err: "Network is unreachable"}                  // ENETUNREACH
-	ErrNoNet          = Error{err: "Machine is not on the network"}           // ENONET
-	ErrNoRoute        = Error{err: "No route to host"}                        // EHOSTUNREACH
-	ErrNotConnected   = Error{err: "Transport endpoint is not connected"}     // ENOTCONN
-
-	ErrNoDevice       = Error{err: "No such device"}            // ENODEV
-	ErrNoDeviceOrAddr = Error{err: "No such device or address"} // ENXIO
-	ErrNoLink         = Error{err: "Link has been severed"}     // ENOLINK
-	ErrNoLock         = Error{err: "No locks available"}        // ENOLCK
-	ErrNoMem          = Error{err: "Cannot allocate memory"}    // ENOMEM
-	ErrNoPackage      = Error{err: "Package not installed"}     // ENOPKG
-
-	ErrBrokenPipe    = Error{err: "Broken pipe"}                 // EPIPE
-	ErrBadAddr       = Error{err: "Bad address"}                 // EFAULT
-	ErrBusy          = Error{err: "Device or resource busy"}     // EBUSY
-	ErrComm          = Error{err: "Communication error on send"} // ECOMM
-	ErrNoBufferSpace = Error{err: "No buffer space available"}   // ENOBUFS
-	ErrNoData        = Error{err: "No data available"}           // ENODATA
-	ErrNoSpace       = Error{err: "file system is full"}         // ENOSPC
-
-	ErrAllreadyInProgress = Error{err: "Operation already in progress"}                 // EALREADY
-	ErrShutdown           = Error{err: "Cannot send after transport endpoint shutdown"} // ESHUTDOWN
-	ErrTimeout            = Error{err: "Connection timed out"}                          // ETIMEDOUT
-
-	ErrIsDir       = Error{err: "Is a directory"}                 // EISDIR
-	ErrIsNamed     = Error{err: "Is a named type file"}           // EISNAM
-	ErrNotBlockDev = Error{err: "Block device required"}          // ENOTBLK
-	ErrNotDir      = Error{err: "not a directory"}                // ENOTDIR
-	ErrNotSock     = Error{err: "Socket operation on non-socket"} // ENOTSOCK
-
-	ErrNotImplemented = Error{err: "Function not implemented"} // ENOSYS
-	ErrOpNoSupported  = Error{err: "Operation not supported"}  // EOPNOTSUPP
-
-	ErrOutOfRange = Error{err: "Numerical argument out of domain"} // EDOM
-	ErrQuota      = Error{err: "Disk quota exceeded"}              // EDQUOT
-	ErrRange      = Error{err: "Numerical result out of range"}    // ERANGE
-	ErrReadOnly   = Error{err: "file is read only"}                // EROFS
-	ErrReadOnlyFS = Error{err: "read only file system"}            // EROFS
-	ErrRemote     = Error{err: "Object is remote"}                 // EREMOTE
-	ErrRemoteIO   = Error{err: "Remote I/O error"}                 // EREMOTEIO
-	ErrRemoved    = Error{err: "file has been removed"}            // EIDRM
-	ErrStreamPipe = Error{err: "Streams pipe error"}               // ESTRPIPE
-
-	ErrNoProto              = Error{err: "Protocol not available"}        // ENOPROTOOPT
-	ErrProtoNoSupport       = Error{err: "Protocol not supported"}        // EPROTONOSUPPORT
-	ErrProtoFamilyNoSupport = Error{err: "Protocol family not supported"} // EPFNOSUPPORT
-	ErrSockNoSupported      = Error{err: "Socket type not supported"}     // ESOCKTNOSUPPORT
-
-	ErrTooManyArgs      = Error{err: "Argument list too long"}            // E2BIG
-	ErrTooManyFiles     = Error{err: "Too many open files"}               // EMFILE
-	ErrTooManyLevels    = Error{err: "Too many levels of symbolic links"} // ELOOP
-	ErrTooManyLinks     = Error{err: "Too many links"}                    // EMLINK
-	ErrTooManyOpenFiles = Error{err: "Too many open files in system"}     // ENFILE
-	ErrTooManyUsers     = Error{err: "Too many users"}                    // EUSERS
-
-	ErrTempUnavailable = Error{err: "Resource temporarily unavailable"} // EAGAIN
-
-	ErrUnknownGroup    = Error{err: "unknown group"}               // EINVAL
-	ErrUnknownOrBadFid = Error{err: "fid unknown or out of range"} // EBADF
-	ErrUnknownUser     = Error{err: "unknown user"}                // EINVAL
+	ErrBadFD    = Error{err: "File descriptor in bad state", errno: ebadfd}
+	ErrBadFid   = Error{err: "bad use of fid", errno: ebadf}
+	ErrFidInUse = Error{err: "fid already in use", errno: ebadf}
+
+	ErrAuth = Error{err: "authentication failed", errno: econnrefused}
+
+	ErrCrossDevice = Error{err: "Invalid cross-device link", errno: exdev}
+	ErrDeadlock    = Error{err: "Resource deadlock avoided", errno: edeadlk}
+	ErrDirNotEmpty = Error{err: "directory is not empty", errno: enotempty}
+
+	ErrExists = Error{err: "file exists", errno: eexist}
+	ErrInUse  = Error{err: "file in use", errno: etxtbsy}
+	ErrTooBig = Error{err: "file too big", errno: efbig}
+
+	ErrIllegalMode   = Error{err: "illegal mode", errno: einval}
+	ErrIllegalName   = Error{err: "illegal name", errno: enametoolong}
+	ErrIllegalOffset = Error{err: "illegal offset", errno: einval}
+	ErrIllegalSeek   = Error{err: "Illegal seek", errno: espipe}
+
+	ErrInProgress  = Error{err: "Operation now in progress", errno: einprogress}
+	ErrInterrupted = Error{err: "Interrupted system call", errno: eintr}
+	ErrInvalidArg  = Error{err: "Invalid argument", errno: einval}
+	ErrIO          = Error{err: "i/o error", errno: eio}
+
+	ErrBadMessage     = Error{err: "Bad message", errno: ebadmsg}
+	ErrMessageTooLong = Error{err: "Message too long", errno: emsgsize}
+	ErrNoMessage      = Error{err: "No message of desired type", errno: enomsg}
+
+	ErrConnAbort      = Error{err: "Software caused connection abort", errno: econnaborted}
+	ErrConnected      = Error{err: "Transport endpoint is already connected", errno: eisconn}
+	ErrConnRefused    = Error{err: "Connection refused", errno: econnrefused}
+	ErrConnReset      = Error{err: "Connection reset by peer", errno: econnreset}
+	ErrHostDown       = Error{err: "Host is down", errno: ehostdown}
+	ErrNetDown        = Error{err: "Network is down", errno: enetdown}
+	ErrNetReset       = Error{err: "Network dropped connection on reset", errno: enetreset}
+	ErrNetUnreachable = Error{err: "Network is unreachable", errno: enetunreach}
+	ErrNoNet          = Error{err: "Machine is not on the network", errno: enonet}
+	ErrNoRoute        = Error{err: "No route to host", errno: ehostunreach}
+	ErrNotConnected   = Error{err: "Transport endpoint is not connected", errno: enotconn}
+
+	ErrNoDevice       = Error{err: "No such device", errno: enodev}
+	ErrNoDeviceOrAddr = Error{err: "No such device or address", errno: enxio}
+	ErrNoLink         = Error{err: "Link has been severed", errno: enolink}
+	ErrNoLock         = Error{err: "No locks available", errno: enolck}
+	ErrNoMem          = Error{err: "Cannot allocate memory", errno: enomem}
+	ErrNoPackage      = Error{err: "Package not installed", errno: enopkg}
+
+	ErrBrokenPipe    = Error{err: "Broken pipe", errno: epipe}
+	ErrBadAddr       = Error{err: "Bad address", errno: efault}
+	ErrBusy          = Error{err: "Device or resource busy", errno: ebusy}
+	ErrComm          = Error{err: "Communication error on send", errno: ecomm}
+	ErrNoBufferSpace = Error{err: "No buffer space available", errno: enobufs}
+	ErrNoData        = Error{err: "No data available", errno: enodata}
+	ErrNoSpace       = Error{err: "file system is full", errno: enospc}
+
+	ErrAllreadyInProgress = Error{err: "Operation already in progress", errno: ealready}
+	ErrShutdown           = Error{err: "Cannot send after transport endpoint shutdown", errno: eshutdown}
+	ErrTimeout            = Error{err: "Connection timed out", errno: etimedout}
+
+	ErrIsDir       = Error{err: "Is a directory", errno: eisdir}
+	ErrIsNamed     = Error{err: "Is a named type file", errno: eisnam}
+	ErrNotBlockDev = Error{err: "Block device required", errno: enotblk}
+	ErrNotDir      = Error{err: "not a directory", errno: enotdir}
+	ErrNotSock     = Error{err: "Socket operation on non-socket", errno: enotsock}
+
+	ErrNotImplemented = Error{err: "Function not implemented", errno: enosys}
+	ErrOpNoSupported  = Error{err: "Operation not supported", errno: eopnotsupp}
+
+	ErrOutOfRange = Error{err: "Numerical argument out of domain", errno: edom}
+	ErrQuota      = Error{err: "Disk quota exceeded", errno: edquot}
+	ErrRange      = Error{err: "Numerical result out of range", errno: erange}
+	ErrReadOnly   = Error{err: "file is read only", errno: erofs}
+	ErrReadOnlyFS = Error{err: "read only file system", errno: erofs}
+	ErrRemote     = Error{err: "Object is remote", errno: eremote}
+	ErrRemoteIO   = Error{err: "Remote I/O error", errno: eremoteio}
+	ErrRemoved    = Error{err: "file has been removed", errno: eidrm}
+	ErrStreamPipe = Error{err: "Streams pipe error", errno: estrpipe}
+
+	ErrNoProto              = Error{err: "Protocol not available", errno: enoprotoopt}
+	ErrProtoNoSupport       = Error{err: "Protocol not supported", errno: eprotonosupport}
+	ErrProtoFamilyNoSupport = Error{err: "Protocol family not supported", errno: epfnosupport}
+	ErrSockNoSupported      = Error{err: "Socket type not supported", errno: esocktnosupport}
+
+	ErrTooManyArgs      = Error{err: "Argument list too long", errno: e2big}
+	ErrTooManyFiles     = Error{err: "Too many open files", errno: emfile}
+	ErrTooManyLevels    = Error{err: "Too many levels of symbolic links", errno: eloop}
+	ErrTooManyLinks     = Error{err: "Too many links", errno: emlink}
+	ErrTooManyOpenFiles = Error{err: "Too many open files in system", errno: enfile}
+	ErrTooManyUsers     = Error{err: "Too many users", errno: eusers}
+
+	ErrTempUnavailable = Error{err: "Resource temporarily unavailable", errno: eagain}
+
+	ErrUnknownGroup    = Error{err: "unknown group", errno: einval}
+	ErrUnknownOrBadFid = Error{err: "fid unknown or out of range", errno: ebadf}
+	ErrUnknownUser     = Error{err: "unknown user", errno: einval}
 )
+
+// errorsByName maps the messages of the errors above to them.
+var errorsByName = func() map[string]Error {
+	errs := []Error{
+		ErrBadAttach, ErrBadOffset, ErrBadCount, ErrBotch, ErrCreateNonDir,
+		ErrDupFid, ErrDupTag, ErrNoAuth, ErrNoCreate, ErrNoRemove, ErrNoStat,
+		ErrNotFound, ErrNoWrite, ErrNoWstat, ErrPerm, ErrUnknownFid, ErrBadDir,
+		ErrWalkNoDir, ErrBadFD, ErrBadFid, ErrFidInUse, ErrAuth, ErrCrossDevice,
+		ErrDeadlock, ErrDirNotEmpty, ErrExists, ErrInUse, ErrTooBig, ErrIllegalMode,
+		ErrIllegalName, ErrIllegalOffset, ErrIllegalSeek, ErrInProgress,
+		ErrInterrupted, ErrInvalidArg, ErrIO, ErrBadMessage, ErrMessageTooLong,
+		ErrNoMessage, ErrConnAbort, ErrConnected, ErrConnRefused, ErrConnReset,
+		ErrHostDown, ErrNetDown, ErrNetReset, ErrNetUnreachable, ErrNoNet,
+		ErrNoRoute, ErrNotConnected, ErrNoDevice, ErrNoDeviceOrAddr, ErrNoLink,
+		ErrNoLock, ErrNoMem, ErrNoPackage, ErrBrokenPipe, ErrBadAddr, ErrBusy,
+		ErrComm, ErrNoBufferSpace, ErrNoData, ErrNoSpace, ErrAllreadyInProgress,
+		ErrShutdown, ErrTimeout, ErrIsDir, ErrIsNamed, ErrNotBlockDev, ErrNotDir,
+		ErrNotSock, ErrNotImplemented, ErrOpNoSupported, ErrOutOfRange, ErrQuota,
+		ErrRange, ErrReadOnly, ErrReadOnlyFS, ErrRemote, ErrRemoteIO, ErrRemoved,
+		ErrStreamPipe, ErrNoProto, ErrProtoNoSupport, ErrProtoFamilyNoSupport,
+		ErrSockNoSupported, ErrTooManyArgs, ErrTooManyFiles, ErrTooManyLevels,
+		ErrTooManyLinks, ErrTooManyOpenFiles, ErrTooManyUsers, ErrTempUnavailable,
+		ErrUnknownGroup, ErrUnknownOrBadFid, ErrUnknownUser,
+	}
+
+	m := make(map[string]Error, len(errs))
+	for _, err := range errs {
+		m[err.err] = err
+	}
+	return m
+}()
+
+// ParseError returns the error above whose message is ename, as received in
+// a Rerror. It returns false if there is none.
+func ParseError(ename string) (Error, bool) {
+	err, ok := errorsByName[ename]
+	return err, ok
+}
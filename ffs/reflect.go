@@ -1,6 +1,7 @@
 package ffs
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
@@ -108,11 +109,16 @@ type paramWrap struct {
 }
 
 var (
-	_ np.Node      = &paramWrap{}
-	_ np.Unwrapper = &paramWrap{}
+	_ np.Node          = &paramWrap{}
+	_ np.Unwrapper     = &paramWrap{}
+	_ np.ContextStater = &paramWrap{}
 )
 
 func (pw *paramWrap) Stat() (np.Stat, error) {
+	return pw.StatContext(context.Background())
+}
+
+func (pw *paramWrap) StatContext(ctx context.Context) (np.Stat, error) {
 	var st np.Stat
 
 	// if val is already a Node, use Stat from it
@@ -121,7 +127,7 @@ func (pw *paramWrap) Stat() (np.Stat, error) {
 	// Mode has to be set
 	if node, ok := pw.val.(np.Node); ok {
 		var err error
-		st, err = node.Stat()
+		st, err = np.StatNode(ctx, node)
 		if err != nil {
 			return st, fmt.Errorf("stat: %w", err)
 		}
@@ -2,7 +2,8 @@ package ffs_test
 
 import (
 	"fmt"
-	"os"
+	"io/fs"
+	"strings"
 	"testing"
 
 	"github.com/noonien/np/ffs"
@@ -17,8 +18,7 @@ func readNode(t *testing.T, v any, path string) ([]byte, error) {
 		return nil, fmt.Errorf("to node: %w", err)
 	}
 
-	mnt, done := nptest.Serve(t, node)
-	defer done()
+	c := nptest.ServePipe(t, node)
 
-	return os.ReadFile(mnt + path) //nolint:wrapcheck
+	return fs.ReadFile(c, strings.TrimPrefix(path, "/")) //nolint:wrapcheck
 }
@@ -1,6 +1,9 @@
 package np
 
 import (
+	"context"
+	"io/fs"
+
 	"go.rbn.im/neinp/message"
 	"go.rbn.im/neinp/qid"
 	"go.rbn.im/neinp/stat"
@@ -9,10 +12,92 @@ import (
 type (
 	OpenMode = message.OpenMode
 	Qid      = qid.Qid
+	QidType  = qid.Type
 	Stat     = stat.Stat
 	Mode     = stat.Mode
 )
 
+// Open modes, as defined in open(5).
+const (
+	ORead   OpenMode = 0
+	OWrite  OpenMode = 1
+	ORdwr   OpenMode = 2
+	OExec   OpenMode = 3
+	OTrunc  OpenMode = 0x10
+	ORclose OpenMode = 0x40
+)
+
+// Mode bits, as defined in stat(5).
+//
+// ModeSymlink, ModeDevice, ModeNamedPipe, ModeSocket, ModeSetuid and
+// ModeSetgid are 9P2000.u extensions.
+const (
+	ModeDir       Mode = 0x80000000
+	ModeAppend    Mode = 0x40000000
+	ModeExcl      Mode = 0x20000000
+	ModeMount     Mode = 0x10000000
+	ModeAuth      Mode = 0x08000000
+	ModeTmp       Mode = 0x04000000
+	ModeSymlink   Mode = 0x02000000
+	ModeDevice    Mode = 0x00800000
+	ModeNamedPipe Mode = 0x00200000
+	ModeSocket    Mode = 0x00100000
+	ModeSetuid    Mode = 0x00080000
+	ModeSetgid    Mode = 0x00040000
+	ModePerm      Mode = 0o777
+)
+
+// modeBits are the Mode bits that have an io/fs equivalent.
+var modeBits = []struct {
+	mode Mode
+	fs   fs.FileMode
+}{
+	{ModeDir, fs.ModeDir},
+	{ModeAppend, fs.ModeAppend},
+	{ModeExcl, fs.ModeExclusive},
+	{ModeTmp, fs.ModeTemporary},
+	{ModeSymlink, fs.ModeSymlink},
+	{ModeDevice, fs.ModeDevice},
+	{ModeNamedPipe, fs.ModeNamedPipe},
+	{ModeSocket, fs.ModeSocket},
+	{ModeSetuid, fs.ModeSetuid},
+	{ModeSetgid, fs.ModeSetgid},
+}
+
+// FileMode converts m to an io/fs file mode.
+func FileMode(m Mode) fs.FileMode {
+	fm := fs.FileMode(m & ModePerm)
+	for _, b := range modeBits {
+		if m&b.mode != 0 {
+			fm |= b.fs
+		}
+	}
+	return fm
+}
+
+// ModeOf converts an io/fs file mode to a Mode.
+func ModeOf(fm fs.FileMode) Mode {
+	m := Mode(fm.Perm())
+	for _, b := range modeBits {
+		if fm&b.fs != 0 {
+			m |= b.mode
+		}
+	}
+	return m
+}
+
+// Qid types, as defined in intro(5).
+const (
+	QTDir     QidType = 0x80
+	QTAppend  QidType = 0x40
+	QTExcl    QidType = 0x20
+	QTMount   QidType = 0x10
+	QTAuth    QidType = 0x08
+	QTTmp     QidType = 0x04
+	QTSymlink QidType = 0x02
+	QTFile    QidType = 0x00
+)
+
 // Node represents a simple filesystem node, that can return a stat
 //
 // Types that implement this interface, can implement other interfaces to
@@ -21,7 +106,14 @@ type (
 //   - Writing: io.WriterAt, io.WriteSeeker, io.Writer (only sequential writes are allowed)
 //   - Closing: io.Closer
 //   - Opening: Opener
-//   - Directories: Dir
+//   - Directories: Dir, DirLister, Creator, UnixCreator, ChildRemover, Renamer
+//   - Removing: Remover
+//   - Changing stat: Wstater, Syncer
+//   - Filesystem information: StatFSer
+//   - 9P2000.u stat fields: UnixStater
+//   - Qids: Identifier
+//   - Fid references: Referencer
+//   - Cancellation: ContextStater, ContextDir, ContextOpener, ContextReaderAt, ContextWriterAt, ContextCloser
 type Node interface {
 	Stat() (Stat, error)
 }
@@ -38,3 +130,181 @@ type Dir interface {
 type Opener interface {
 	Open(OpenMode) (val any, iounit uint32, err error)
 }
+
+// Creator allows a Dir to create new children.
+//
+// perm contains the permission bits of the new file, and ModeDir if a
+// directory should be created. The returned Node is opened by the server with
+// mode, unless a directory is created with 9P2000.L's Tmkdir.
+//
+// Creator is looked up with UnwrapValue, so Nodes that wrap a Dir can wrap a
+// Creator as well.
+type Creator interface {
+	Create(name string, perm Mode, mode OpenMode) (Node, error)
+}
+
+// UnixCreator allows a Dir to create 9P2000.u special files.
+//
+// extension describes the file to create, see UnixStat.Extension.
+type UnixCreator interface {
+	CreateUnix(name string, perm Mode, mode OpenMode, extension string) (Node, error)
+}
+
+// Remover allows a Node to remove itself.
+//
+// Directories that are not empty can refuse to be removed by returning
+// ErrDirNotEmpty. The fid used for the remove is clunked, and the opened
+// value closed, whether the remove succeeds or not.
+type Remover interface {
+	Remove() error
+}
+
+// ChildRemover allows a Dir to remove one of its children by name.
+//
+// It is used when the removed Node does not implement Remover, and has the
+// same semantics.
+type ChildRemover interface {
+	RemoveChild(name string) error
+}
+
+// Renamer allows a Dir to move one of its children into another directory.
+//
+// newdir is the Node the child should be moved to, and can be the Renamer itself.
+// Renames within the same directory fall back to Wstater if Renamer is not implemented.
+type Renamer interface {
+	Rename(oldname string, newdir Node, newname string) error
+}
+
+// StatField is a set of Stat fields.
+type StatField uint32
+
+const (
+	StatName StatField = 1 << iota
+	StatLength
+	StatMode
+	StatMtime
+	StatAtime
+	StatUid
+	StatGid
+)
+
+// StatChanges is a request to change a Node's Stat.
+//
+// Only the fields listed in Fields should be changed, the other fields of Stat
+// have undefined values.
+type StatChanges struct {
+	Stat
+	Fields StatField
+}
+
+// Has returns true if all the fields in f should be changed.
+func (sc *StatChanges) Has(f StatField) bool {
+	return sc.Fields&f == f
+}
+
+// Wstater allows changing a Node's Stat (rename, truncate, chmod, chown, etc).
+type Wstater interface {
+	Wstat(StatChanges) error
+}
+
+// Syncer allows a Node to commit its contents to stable storage.
+type Syncer interface {
+	Sync() error
+}
+
+// FSStat contains information about a filesystem, as returned by statfs(2).
+type FSStat struct {
+	Type    uint32
+	Bsize   uint32
+	Blocks  uint64
+	Bfree   uint64
+	Bavail  uint64
+	Files   uint64
+	Ffree   uint64
+	Fsid    uint64
+	Namelen uint32
+}
+
+// StatFSer allows a Node to return information about the filesystem it's part of.
+type StatFSer interface {
+	StatFS() (FSStat, error)
+}
+
+// ContextStater is a Node that can stop a Stat when ctx is done.
+//
+// ctx is done when the request is flushed, times out or the connection is
+// closed. StatContext is used instead of Stat.
+type ContextStater interface {
+	StatContext(ctx context.Context) (Stat, error)
+}
+
+// ContextDir is a Dir that can stop listing and walking when ctx is done.
+//
+// It has to be implemented by the same value that implements Dir, its methods
+// are used instead of the Dir methods.
+type ContextDir interface {
+	ChildrenContext(ctx context.Context) ([]Stat, error)
+	WalkContext(ctx context.Context, name string) (Node, error)
+}
+
+// ContextOpener is an Opener that can stop opening when ctx is done.
+type ContextOpener interface {
+	OpenContext(ctx context.Context, mode OpenMode) (val any, iounit uint32, err error)
+}
+
+// ContextReaderAt is an io.ReaderAt that can stop reading when ctx is done.
+type ContextReaderAt interface {
+	ReadAtContext(ctx context.Context, p []byte, off int64) (int, error)
+}
+
+// ContextWriterAt is an io.WriterAt that can stop writing when ctx is done.
+type ContextWriterAt interface {
+	WriteAtContext(ctx context.Context, p []byte, off int64) (int, error)
+}
+
+// ContextCloser is an io.Closer that gets the context of the clunk, or a
+// context that is not canceled when the connection ends.
+type ContextCloser interface {
+	CloseContext(ctx context.Context) error
+}
+
+// DirLister allows a Dir to list its children a few at a time, as the
+// directory is read, instead of all at once with Children.
+//
+// It has to be implemented by the same value that implements Dir, and is
+// used instead of Children.
+type DirLister interface {
+	// List starts a new listing of the children. It's called when the
+	// directory is opened, and when it's read again from the start.
+	List(ctx context.Context) (DirIterator, error)
+}
+
+// DirIterator returns the children of a directory, in order.
+//
+// If it implements io.Closer, it's closed when the listing is done.
+type DirIterator interface {
+	// Next returns up to n of the next children, n is only a hint. Once all
+	// children were returned, it returns io.EOF, possibly with the last
+	// children.
+	Next(ctx context.Context, n int) ([]Stat, error)
+}
+
+// Identifier allows a Node to have an identity that doesn't change when it's
+// renamed, so that it keeps its qid path. It's used by QidAllocator for Nodes
+// that don't set Stat.Qid.Path.
+//
+// The identity must be comparable, for example a pointer or an inode number.
+type Identifier interface {
+	Identity() any
+}
+
+// Referencer allows a Node to track the fids that refer to it.
+//
+// A fid refers to the Nodes it was walked through or created, and to the Node
+// it points to. Ref is called when a fid starts referring to the Node, Unref
+// when the fid is clunked or removed, after its opened value was closed, or
+// when the connection ends.
+type Referencer interface {
+	Ref()
+	Unref()
+}
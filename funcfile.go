@@ -0,0 +1,154 @@
+package np
+
+import (
+	"context"
+	"io"
+)
+
+// FuncFile is a file whose content is made by functions.
+//
+// Read is called once every time the file is opened for reading, the content
+// it returns is served until the fid is clunked. Reads that span several
+// messages see the same content, even if it changes in the meantime.
+//
+// Writes are collected until the fid is clunked, Write is then called with
+// all the data written, at the offsets it was written to, even if nothing was
+// written. The error Write returns is the error of the clunk. Writes past
+// maxFuncWrite fail with ErrBadOffset.
+type FuncFile struct {
+	Name string
+
+	// Perm are the permissions of the file. If 0, the file can be read if
+	// Read is set and written if Write is set.
+	Perm Mode
+
+	Read  func(ctx context.Context) ([]byte, error)
+	Write func(ctx context.Context, data []byte) error
+}
+
+// ReadFunc returns a read-only FuncFile with the content made by fn.
+func ReadFunc(name string, fn func(ctx context.Context) ([]byte, error)) *FuncFile {
+	return &FuncFile{Name: name, Read: fn}
+}
+
+// WriteFunc returns a write-only FuncFile that passes what was written to fn.
+func WriteFunc(name string, fn func(ctx context.Context, data []byte) error) *FuncFile {
+	return &FuncFile{Name: name, Write: fn}
+}
+
+// maxFuncWrite is the most data that's collected for Write.
+const maxFuncWrite = 16 << 20
+
+// funcFD is an opened FuncFile.
+type funcFD struct {
+	ff *FuncFile
+
+	// data is the content made by Read
+	data []byte
+
+	// writing is true if opened for writing, written is the data written
+	writing bool
+	written []byte
+}
+
+var (
+	_ Node          = &FuncFile{}
+	_ ContextOpener = &FuncFile{}
+	_ io.ReaderAt   = &funcFD{}
+	_ io.WriterAt   = &funcFD{}
+	_ io.Closer     = &funcFD{}
+	_ ContextCloser = &funcFD{}
+)
+
+func (ff *FuncFile) Stat() (Stat, error) {
+	perm := ff.Perm
+	if perm == 0 {
+		if ff.Read != nil {
+			perm |= 0o444
+		}
+		if ff.Write != nil {
+			perm |= 0o222
+		}
+	}
+
+	return Stat{
+		Qid:  Qid{Type: QTFile},
+		Mode: perm & ModePerm,
+		Name: ff.Name,
+	}, nil
+}
+
+func (ff *FuncFile) Open(mode OpenMode) (any, uint32, error) {
+	return ff.OpenContext(context.Background(), mode)
+}
+
+// OpenContext makes the content of the file, if it's opened for reading.
+func (ff *FuncFile) OpenContext(ctx context.Context, mode OpenMode) (any, uint32, error) {
+	fd := &funcFD{ff: ff}
+
+	switch mode & 3 {
+	case ORead, OExec:
+		if ff.Read == nil {
+			return nil, 0, ErrPerm
+		}
+	case OWrite:
+		if ff.Write == nil {
+			return nil, 0, ErrPerm
+		}
+		fd.writing = true
+	case ORdwr:
+		if ff.Read == nil || ff.Write == nil {
+			return nil, 0, ErrPerm
+		}
+		fd.writing = true
+	}
+
+	if mode&3 != OWrite {
+		data, err := ff.Read(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		fd.data = data
+	}
+
+	return fd, 0, nil
+}
+
+func (fd *funcFD) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrBadOffset
+	}
+	if off >= int64(len(fd.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, fd.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (fd *funcFD) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off > maxFuncWrite || int64(len(p)) > maxFuncWrite-off {
+		return 0, ErrBadOffset
+	}
+
+	if end := off + int64(len(p)); end > int64(len(fd.written)) {
+		fd.written = append(fd.written, make([]byte, end-int64(len(fd.written)))...)
+	}
+	return copy(fd.written[off:], p), nil
+}
+
+func (fd *funcFD) Close() error {
+	return fd.CloseContext(context.Background())
+}
+
+// CloseContext passes the written data to Write, with the context of the
+// clunk.
+func (fd *funcFD) CloseContext(ctx context.Context) error {
+	if !fd.writing {
+		return nil
+	}
+	return fd.ff.Write(ctx, fd.written)
+}
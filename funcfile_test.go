@@ -0,0 +1,118 @@
+package np_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/noonien/np"
+	"github.com/noonien/np/nptest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFuncFileRead(t *testing.T) {
+	t.Parallel()
+
+	var gen int32
+	ff := np.ReadFunc("gen", func(ctx context.Context) ([]byte, error) {
+		n := atomic.AddInt32(&gen, 1)
+		return []byte("generation " + strconv.Itoa(int(n))), nil
+	})
+	c := nptest.ServePipe(t, ff)
+	ctx := context.Background()
+
+	f, err := c.Root.Walk(ctx)
+	require.Nil(t, err)
+	require.Nil(t, f.Open(ctx, np.ORead))
+
+	// reads see the content made when the file was opened
+	buf := make([]byte, 4)
+	n, err := f.Read(ctx, buf, 0)
+	require.Nil(t, err)
+	require.Equal(t, "gene", string(buf[:n]))
+
+	atomic.AddInt32(&gen, 10)
+	buf = make([]byte, 32)
+	n, err = f.Read(ctx, buf, 4)
+	require.Nil(t, err)
+	require.Equal(t, "ration 1", string(buf[:n]))
+	require.Nil(t, f.Clunk(ctx))
+
+	// a new open makes new content
+	f, err = c.Root.Walk(ctx)
+	require.Nil(t, err)
+	require.Nil(t, f.Open(ctx, np.ORead))
+	n, err = f.Read(ctx, buf, 0)
+	require.Nil(t, err)
+	require.Equal(t, "generation 12", string(buf[:n]))
+
+	// it can't be written
+	f, err = c.Root.Walk(ctx)
+	require.Nil(t, err)
+	require.Error(t, f.Open(ctx, np.OWrite))
+}
+
+func TestFuncFileWrite(t *testing.T) {
+	t.Parallel()
+
+	var got []byte
+	var fail error
+	ff := np.WriteFunc("ctl", func(ctx context.Context, data []byte) error {
+		got = data
+		return fail
+	})
+	c := nptest.ServePipe(t, ff)
+	ctx := context.Background()
+
+	f, err := c.Root.Walk(ctx)
+	require.Nil(t, err)
+	require.Nil(t, f.Open(ctx, np.OWrite))
+
+	_, err = f.Write(ctx, []byte("world"), 6)
+	require.Nil(t, err)
+	_, err = f.Write(ctx, []byte("hello "), 0)
+	require.Nil(t, err)
+	require.Nil(t, got)
+
+	// the data is passed to Write on clunk
+	require.Nil(t, f.Clunk(ctx))
+	require.Equal(t, "hello world", string(got))
+
+	// the error of Write is the error of the clunk
+	fail = np.ErrInvalidArg
+	f, err = c.Root.Walk(ctx)
+	require.Nil(t, err)
+	require.Nil(t, f.Open(ctx, np.OWrite))
+	require.ErrorIs(t, f.Clunk(ctx), np.ErrInvalidArg)
+	require.Empty(t, got)
+}
+
+func TestFuncFileWriteBound(t *testing.T) {
+	t.Parallel()
+
+	called := false
+	ff := np.WriteFunc("ctl", func(ctx context.Context, data []byte) error {
+		called = true
+		if len(data) != 0 {
+			return errors.New("unexpected data")
+		}
+		return nil
+	})
+	c := nptest.ServePipe(t, ff)
+	ctx := context.Background()
+
+	f, err := c.Root.Walk(ctx)
+	require.Nil(t, err)
+	require.Nil(t, f.Open(ctx, np.OWrite))
+
+	for _, off := range []int64{1 << 62, math.MaxInt64 - 1} {
+		_, err = f.Write(ctx, []byte("data"), off)
+		require.ErrorIs(t, err, np.ErrBadOffset)
+	}
+
+	require.Nil(t, f.Clunk(ctx))
+	require.True(t, called)
+}
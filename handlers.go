@@ -1,35 +1,41 @@
 package np
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"strings"
 	"sync"
 
 	"go.rbn.im/neinp/message"
 	"go.rbn.im/neinp/qid"
-	"go.rbn.im/neinp/stat"
 )
 
 // walk starts from the root and walks the path from fd.path to get a Node.
-func (s *server) walkfd(fd *fd) (Node, error) {
-	node := s.root
-	for _, name := range fd.path {
+func (s *server) walkfd(ctx context.Context, fd *fd) (Node, error) {
+	if fd.auth != nil {
+		return nil, ErrBadFid
+	}
+
+	node := fd.root
+	for _, name := range fd.curPath() {
 		dir, ok := UnwrapValue[Dir](node)
 		if !ok {
 			return nil, ErrWalkNoDir
 		}
 
 		var err error
-		if node, err = dir.Walk(name); err != nil {
+		if node, err = WalkDir(ctx, dir, name); err != nil {
 			return nil, fmt.Errorf("walk fd: %w", err)
 		}
 	}
 	return node, nil
 }
 
-func (s *server) walk(m message.TWalk) (*message.RWalk, error) { //nolint:funlen
-	fd, _ := s.fids.Get(m.Fid).(*fd)
+func (s *server) walk(ctx context.Context, m message.TWalk) (*message.RWalk, error) { //nolint:funlen
+	fd := s.fids.Get(m.Fid)
 	if fd == nil {
 		return nil, ErrUnknownFid
 	}
@@ -40,42 +46,48 @@ func (s *server) walk(m message.TWalk) (*message.RWalk, error) { //nolint:funlen
 		}
 	}
 
-	node, err := s.walkfd(fd)
+	node, err := s.walkfd(ctx, fd)
 	if err != nil {
 		return nil, ErrWalkNoDir
 	}
 
-	path := make([]string, 0, len(fd.path)+len(m.Wname))
-	path = append(path, fd.path...)
+	path := make([]string, 0, len(fd.curPath())+len(m.Wname))
+	path = append(path, fd.curPath()...)
+	refs := append([]Referencer{}, fd.refs...)
 
 	qids := make([]qid.Qid, 0, len(m.Wname))
 	for i, name := range m.Wname {
-		if name == ".." {
-			pfd := fd.walk(m.Wname[:i]...).walk("..")
-			if node, err = s.walkfd(pfd); err != nil {
-				break
-			}
-		}
-
 		dir, ok := UnwrapValue[Dir](node)
 		if !ok {
 			err = ErrWalkNoDir
 			break
 		}
 
-		// TODO: permissions
-
-		if node, err = dir.Walk(name); err != nil {
+		if err = s.checkPerm(ctx, node, fd.aname, path, fd.uname, permExec); err != nil {
 			break
 		}
 
+		if name == ".." {
+			pfd := fd.walk(m.Wname[:i+1]...)
+			if node, err = s.walkfd(ctx, pfd); err != nil {
+				break
+			}
+			path = append(path[:0], pfd.curPath()...)
+			refs = refs[:len(path)]
+		} else {
+			if node, err = WalkDir(ctx, dir, name); err != nil {
+				break
+			}
+			path = append(path, name)
+			refs = append(refs, referencer(node))
+		}
+
 		var st Stat
-		if st, err = node.Stat(); err != nil {
+		if st, err = StatNode(ctx, node); err != nil {
 			break
 		}
 
-		path = append(path, name)
-		if err = s.fillstat(&st, true, path...); err != nil {
+		if err = s.fillstat(fd.aname, node, &st, true, path...); err != nil {
 			break
 		}
 
@@ -90,32 +102,54 @@ func (s *server) walk(m message.TWalk) (*message.RWalk, error) { //nolint:funlen
 	}
 
 	nfd := fd.walk(m.Wname...)
+	nfd.refs = refs
+	nfd.ref()
 	s.fids.Set(m.Newfid, nfd)
+	if m.Fid == m.Newfid {
+		fd.unref()
+	}
 	return &message.RWalk{Wqid: qids}, nil
 }
 
-func (s *server) open(m message.TOpen) (*message.ROpen, error) {
-	fd, _ := s.fids.Get(m.Fid).(*fd)
+func (s *server) open(ctx context.Context, m message.TOpen) (*message.ROpen, error) {
+	fd := s.fids.Get(m.Fid)
 	if fd == nil {
 		return nil, ErrUnknownFid
 	}
 
-	node, err := s.walkfd(fd)
-	if fd == nil {
+	node, err := s.walkfd(ctx, fd)
+	if err != nil {
 		return nil, err
 	}
 
-	// TODO: check permissions
+	if err = s.checkPerm(ctx, node, fd.aname, fd.curPath(), fd.uname, openPerm(m.Mode)); err != nil {
+		return nil, err
+	}
+
+	qid, iounit, err := s.openfd(ctx, fd, node, m.Mode)
+	if err != nil {
+		return nil, err
+	}
+
+	return &message.ROpen{
+		Qid:    qid,
+		Iounit: iounit,
+	}, nil
+}
 
+// openfd opens node, which fd points to.
+//
+// Permissions have to be checked by the caller.
+func (s *server) openfd(ctx context.Context, fd *fd, node Node, mode OpenMode) (Qid, uint32, error) {
 	fd.mu.Lock()
 	defer fd.mu.Unlock()
 
-	var iounit uint32
-	if o, ok := UnwrapValue[Opener](node); ok { //nolint:nestif
-		var v any
-		if v, iounit, err = o.Open(m.Mode); err != nil {
-			return nil, fmt.Errorf("open: %w", err)
-		}
+	v, iounit, opened, err := openNode(ctx, node, mode)
+	if err != nil {
+		return Qid{}, 0, fmt.Errorf("open: %w", err)
+	}
+
+	if opened { //nolint:nestif
 		if vnode, ok := v.(Node); ok {
 			node = vnode
 		} else {
@@ -124,60 +158,208 @@ func (s *server) open(m message.TOpen) (*message.ROpen, error) {
 				Val:  v,
 			}
 		}
-		fd.open = node
 	} else if dir, ok := UnwrapValue[Dir](node); ok {
 		dnode, ok := dir.(Node)
 		if !ok {
 			dnode = node
 		}
-		if node, err = s.newDir(dnode, dir, fd.path); err != nil {
-			return nil, err
+		if node, err = s.newDir(ctx, dnode, dir, fd.aname, fd.curPath()); err != nil {
+			return Qid{}, 0, err
 		}
-		fd.open = node
 	}
+	fd.open = node
+	fd.mode = mode
 
 	var st Stat
-	if st, err = node.Stat(); err != nil {
-		return nil, fmt.Errorf("stat: %w", err)
+	if st, err = StatNode(ctx, node); err != nil {
+		return Qid{}, 0, fmt.Errorf("stat: %w", err)
 	}
 
-	if err = s.fillstat(&st, true, fd.path...); err != nil {
+	if err = s.fillstat(fd.aname, node, &st, true, fd.curPath()...); err != nil {
+		return Qid{}, 0, err
+	}
+
+	return st.Qid, iounit, nil
+}
+
+func (s *server) create(ctx context.Context, m message.TCreate, extension string) (*message.RCreate, error) {
+	fd := s.fids.Get(m.Fid)
+	if fd == nil {
+		return nil, ErrUnknownFid
+	}
+
+	if m.Perm&ModeDir != 0 && m.Mode&^ORclose != ORead {
+		return nil, ErrIllegalMode
+	}
+
+	perm, err := s.createPerm(ctx, fd, m.Perm)
+	if err != nil {
 		return nil, err
 	}
 
-	return &message.ROpen{
-		Qid:    st.Qid,
+	qid, iounit, err := s.createfd(ctx, m.Fid, m.Name, perm, m.Mode, extension)
+	if err != nil {
+		return nil, err
+	}
+
+	return &message.RCreate{
+		Qid:    qid,
 		Iounit: iounit,
 	}, nil
 }
 
-func (s *server) create(m message.TCreate) (*message.RCreate, error) {
-	return nil, ErrNoCreate
+// createfd creates name in the directory fid points to, the fid is then
+// changed to point to the new Node, which is opened with mode.
+func (s *server) createfd(ctx context.Context, fid uint32, name string, perm Mode, mode OpenMode, extension string) (Qid, uint32, error) {
+	fd := s.fids.Get(fid)
+	if fd == nil {
+		return Qid{}, 0, ErrUnknownFid
+	}
+
+	fd.mu.Lock()
+	opened := fd.open != nil
+	fd.mu.Unlock()
+	if opened {
+		return Qid{}, 0, ErrBadFid
+	}
+
+	node, err := s.createChild(ctx, fd, name, perm, mode, extension)
+	if err != nil {
+		return Qid{}, 0, err
+	}
+
+	nfd := fd.walk(name)
+	nfd.refs = append(append([]Referencer{}, fd.refs...), referencer(node))
+	nfd.ref()
+	s.fids.Set(fid, nfd)
+	fd.unref()
+
+	return s.openfd(ctx, nfd, node, mode)
+}
+
+// createPerm returns perm limited by the permissions of the directory fd
+// points to, see open(5).
+func (s *server) createPerm(ctx context.Context, fd *fd, perm Mode) (Mode, error) {
+	dnode, err := s.walkfd(ctx, fd)
+	if err != nil {
+		return 0, err
+	}
+
+	dst, err := StatNode(ctx, dnode)
+	if err != nil {
+		return 0, fmt.Errorf("stat: %w", err)
+	}
+
+	if err = s.fillstat(fd.aname, dnode, &dst, false, fd.curPath()...); err != nil {
+		return 0, err
+	}
+
+	if perm&ModeDir != 0 {
+		perm &= ^Mode(0o777) | dst.Mode&0o777
+	} else {
+		perm &= ^Mode(0o666) | dst.Mode&0o666
+	}
+	return perm, nil
+}
+
+// createChild creates name in the directory fd points to.
+//
+// If extension is not empty, a 9P2000.u special file is created.
+func (s *server) createChild(ctx context.Context, fd *fd, name string, perm Mode, mode OpenMode, extension string) (Node, error) {
+	if !validName(name) {
+		return nil, ErrIllegalName
+	}
+
+	node, err := s.walkfd(ctx, fd)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := UnwrapValue[Dir](node); !ok {
+		return nil, ErrCreateNonDir
+	}
+
+	if err = s.checkPerm(ctx, node, fd.aname, fd.curPath(), fd.uname, permWrite); err != nil {
+		return nil, err
+	}
+
+	var child Node
+	if extension != "" {
+		c, ok := UnwrapValue[UnixCreator](node)
+		if !ok {
+			return nil, ErrNoCreate
+		}
+
+		if child, err = c.CreateUnix(name, perm, mode, extension); err != nil {
+			return nil, fmt.Errorf("create: %w", err)
+		}
+	} else {
+		c, ok := UnwrapValue[Creator](node)
+		if !ok {
+			return nil, ErrNoCreate
+		}
+
+		if child, err = c.Create(name, perm, mode); err != nil {
+			return nil, fmt.Errorf("create: %w", err)
+		}
+	}
+
+	s.touch(ctx, node, fd.aname, fd.curPath())
+	return child, nil
+}
+
+// childPath returns the path of the child name of the file at path.
+func childPath(path []string, name string) []string {
+	return append(append(make([]string, 0, len(path)+1), path...), name)
+}
+
+// validName returns true if name can be used as a file name.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
 }
 
-func (s *server) read(m message.TRead) (*message.RRead, error) {
-	fd, _ := s.fids.Get(m.Fid).(*fd)
+func (s *server) read(ctx context.Context, m message.TRead) (*message.RRead, error) {
+	fd := s.fids.Get(m.Fid)
 	if fd == nil {
 		return nil, ErrUnknownFid
 	}
 
-	fd.mu.Lock()
+	ctx, cancel := fd.context(ctx)
+	defer cancel()
+
+	if err := fd.mu.LockContext(ctx); err != nil {
+		return nil, err
+	}
 	defer fd.mu.Unlock()
 
 	var err error
 	node := fd.open
 	if node == nil {
-		if node, err = s.walkfd(fd); err != nil {
+		if node, err = s.walkfd(ctx, fd); err != nil {
 			return nil, err
 		}
+		if err = s.checkPerm(ctx, node, fd.aname, fd.curPath(), fd.uname, permRead); err != nil {
+			return nil, err
+		}
+	} else if !canRead(fd.mode) {
+		return nil, ErrPerm
 	}
 
-	// TODO: permissions
+	if m.Offset > math.MaxInt64 {
+		return nil, ErrBadOffset
+	}
+
+	count := m.Count
+	if max := s.maxCount(); count > max {
+		count = max
+	}
 
 	var n int
-	buf := make([]byte, m.Count)
+	buf := make([]byte, count)
 
-	if ra, ok := UnwrapValue[io.ReaderAt](node); ok {
+	if ra, ok := UnwrapValue[ContextReaderAt](node); ok {
+		n, err = ra.ReadAtContext(ctx, buf, int64(m.Offset))
+	} else if ra, ok := UnwrapValue[io.ReaderAt](node); ok {
 		// log.Printf("%v node is ReaderAt", fd.path)
 		n, err = ra.ReadAt(buf, int64(m.Offset))
 	} else if rs, ok := UnwrapValue[io.ReadSeeker](node); ok {
@@ -195,28 +377,40 @@ func (s *server) read(m message.TRead) (*message.RRead, error) {
 	return &message.RRead{Count: uint32(n), Data: buf[:n]}, nil
 }
 
-func (s *server) write(m message.TWrite) (*message.RWrite, error) {
-	fd, _ := s.fids.Get(m.Fid).(*fd)
+func (s *server) write(ctx context.Context, m message.TWrite) (*message.RWrite, error) {
+	fd := s.fids.Get(m.Fid)
 	if fd == nil {
 		return nil, ErrUnknownFid
 	}
+	if m.Offset > math.MaxInt64 {
+		return nil, ErrBadOffset
+	}
 
-	fd.mu.Lock()
+	ctx, cancel := fd.context(ctx)
+	defer cancel()
+
+	if err := fd.mu.LockContext(ctx); err != nil {
+		return nil, err
+	}
 	defer fd.mu.Unlock()
 
 	var err error
 	node := fd.open
 	if node == nil {
-		var err error
-		if node, err = s.walkfd(fd); err != nil {
+		if node, err = s.walkfd(ctx, fd); err != nil {
+			return nil, err
+		}
+		if err = s.checkPerm(ctx, node, fd.aname, fd.curPath(), fd.uname, permWrite); err != nil {
 			return nil, err
 		}
+	} else if !canWrite(fd.mode) {
+		return nil, ErrPerm
 	}
 
-	// TODO: permissions
-
 	var n int
-	if wa, ok := UnwrapValue[io.WriterAt](node); ok {
+	if wa, ok := UnwrapValue[ContextWriterAt](node); ok {
+		n, err = wa.WriteAtContext(ctx, m.Data, int64(m.Offset))
+	} else if wa, ok := UnwrapValue[io.WriterAt](node); ok {
 		n, err = wa.WriteAt(m.Data, int64(m.Offset))
 	} else if ws, ok := UnwrapValue[io.WriteSeeker](node); ok {
 		if _, err = ws.Seek(int64(m.Offset), io.SeekStart); err != nil {
@@ -231,135 +425,323 @@ func (s *server) write(m message.TWrite) (*message.RWrite, error) {
 		return nil, fmt.Errorf("write: %w", err)
 	}
 
+	s.touch(ctx, node, fd.aname, fd.curPath())
 	return &message.RWrite{Count: uint32(n)}, nil
 }
 
-func (s *server) clunk(m message.TClunk) error {
-	fd, _ := s.fids.Get(m.Fid).(*fd)
+func (s *server) clunk(ctx context.Context, m message.TClunk) error {
+	fd := s.fids.Delete(m.Fid)
 	if fd == nil {
 		return ErrUnknownFid
 	}
-	s.fids.Delete(m.Fid)
+	fd.clunked()
 
+	fd.mu.Lock()
+	rclose := fd.open != nil && fd.mode&ORclose != 0
+	fd.mu.Unlock()
+
+	err := s.clunkfd(ctx, fd)
+	if rclose {
+		if rerr := s.removefd(ctx, fd); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// clunkfd interrupts the requests of fd, closes the value it has opened, if
+// any, and drops its references.
+func (s *server) clunkfd(ctx context.Context, fd *fd) error {
+	fd.clunked()
 	fd.mu.Lock()
 	defer fd.mu.Unlock()
+	defer fd.unref()
 
-	var err error
 	node := fd.open
-	if node != nil {
-		if node, err = s.walkfd(fd); err != nil {
-			return err
+	fd.open = nil
+
+	var err error
+	if c, ok := UnwrapValue[ContextCloser](node); ok {
+		err = c.CloseContext(ctx)
+	} else if c, ok := UnwrapValue[io.Closer](node); ok {
+		err = c.Close()
+	}
+	if fd.listing != nil {
+		if lerr := fd.listing.Close(); err == nil {
+			err = lerr
 		}
+		fd.listing = nil
+	}
+	if err != nil {
+		return fmt.Errorf("close: %w", err)
 	}
 
-	if c, ok := UnwrapValue[io.Closer](node); ok {
-		err := c.Close()
-		if err != nil {
-			return fmt.Errorf("close: %w", err)
-		}
+	return nil
+}
+
+func (s *server) remove(ctx context.Context, m message.TRemove) (*message.RRemove, error) {
+	fd := s.fids.Delete(m.Fid)
+	if fd == nil {
+		return nil, ErrUnknownFid
+	}
+
+	// the fid is clunked even if the remove fails
+	err := s.removefd(ctx, fd)
+	if cerr := s.clunkfd(ctx, fd); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &message.RRemove{}, nil
+}
+
+// removefd removes the Node fd points to.
+func (s *server) removefd(ctx context.Context, fd *fd) error {
+	if len(fd.curPath()) == 0 {
+		return ErrNoRemove
+	}
+
+	node, err := s.walkfd(ctx, fd)
+	if err != nil {
+		return err
+	}
+
+	pfd := fd.walk("..")
+	parent, err := s.walkfd(ctx, pfd)
+	if err != nil {
+		return err
 	}
 
+	if err = s.checkPerm(ctx, parent, pfd.aname, pfd.curPath(), fd.uname, permWrite); err != nil {
+		return err
+	}
+
+	if err = s.removeNode(parent, node, fd.curPath()[len(fd.curPath())-1]); err != nil {
+		return err
+	}
+
+	s.removed(fd.aname, fd.curPath())
+	s.touch(ctx, parent, pfd.aname, pfd.curPath())
 	return nil
 }
 
-func (s *server) remove(m message.TRemove) (*message.RRemove, error) {
-	return nil, ErrNoRemove
+// removeNode removes node, which is the child called name of parent.
+//
+// Permissions have to be checked by the caller.
+func (s *server) removeNode(parent, node Node, name string) error {
+	if r, ok := UnwrapValue[Remover](node); ok {
+		if err := r.Remove(); err != nil {
+			return fmt.Errorf("remove: %w", err)
+		}
+		return nil
+	}
+
+	if r, ok := UnwrapValue[ChildRemover](parent); ok {
+		if err := r.RemoveChild(name); err != nil {
+			return fmt.Errorf("remove: %w", err)
+		}
+		return nil
+	}
+
+	return ErrNoRemove
 }
 
-func (s *server) stat(m message.TStat) (*message.RStat, error) {
-	fd, _ := s.fids.Get(m.Fid).(*fd)
+func (s *server) stat(ctx context.Context, m message.TStat) (any, error) {
+	fd := s.fids.Get(m.Fid)
 	if fd == nil {
 		return nil, ErrUnknownFid
 	}
 
-	node, err := s.walkfd(fd)
-	if fd == nil {
+	node, err := s.walkfd(ctx, fd)
+	if err != nil {
 		return nil, err
 	}
 
-	st, err := node.Stat()
+	st, err := StatNode(ctx, node)
 	if err != nil {
 		return nil, fmt.Errorf("stat: %w", err)
 	}
 
-	if err = s.fillstat(&st, false, fd.path...); err != nil {
+	if err = s.fillstat(fd.aname, node, &st, false, fd.curPath()...); err != nil {
 		return nil, err
 	}
 
+	if s.dialect() == dialectU {
+		us, err := nodeUnixStat(node, &st)
+		if err != nil {
+			return nil, fmt.Errorf("unix stat: %w", err)
+		}
+		return &rstatu{Stat: st, UnixStat: us}, nil
+	}
+
 	return &message.RStat{
 		Stat: st,
 	}, nil
 }
 
-func (s *server) wstat(m message.TWstat) error {
-	return nil
-}
+func (s *server) wstat(ctx context.Context, m message.TWstat) error {
+	fd := s.fids.Get(m.Fid)
+	if fd == nil {
+		return ErrUnknownFid
+	}
 
-func (s *server) auth(m message.TAuth) (*message.RAuth, error) {
-	return &message.RAuth{}, nil
-}
+	sc, err := wstatChanges(&m.Stat)
+	if err != nil {
+		return err
+	}
 
-func (s *server) attach(m message.TAttach) (*message.RAttach, error) {
-	st, err := s.root.Stat()
+	node, err := s.walkfd(ctx, fd)
 	if err != nil {
-		return nil, fmt.Errorf("stat: %w", err)
+		return err
 	}
 
-	if err = s.fillstat(&st, true); err != nil {
-		return nil, err
+	// a wstat that doesn't change anything is a request to sync the file
+	if sc.Fields == 0 {
+		if sy, ok := UnwrapValue[Syncer](node); ok {
+			if err = sy.Sync(); err != nil {
+				return fmt.Errorf("sync: %w", err)
+			}
+		}
+		return nil
 	}
 
-	s.fids.Set(m.Fid, newfd())
-	return &message.RAttach{
-		Qid: st.Qid,
-	}, nil
-}
+	st, err := StatNode(ctx, node)
+	if err != nil {
+		return fmt.Errorf("stat: %w", err)
+	}
+
+	if err = s.fillstat(fd.aname, node, &st, false, fd.curPath()...); err != nil {
+		return err
+	}
+
+	if sc.Has(StatMode) && (sc.Mode^st.Mode)&ModeDir != 0 {
+		return ErrNoWstat
+	}
+	if sc.Has(StatLength) && st.IsDir() && sc.Length != 0 {
+		return ErrNoWstat
+	}
+	if sc.Has(StatName) {
+		if len(fd.curPath()) == 0 || !validName(sc.Name) {
+			return ErrIllegalName
+		}
+		if sc.Name == st.Name {
+			sc.Fields &^= StatName
+		}
+	}
+
+	if err = s.checkWstat(ctx, fd, &st, sc); err != nil {
+		return err
+	}
+
+	ws, ok := UnwrapValue[Wstater](node)
+	if !ok {
+		return ErrNoWstat
+	}
+
+	if err = ws.Wstat(sc); err != nil {
+		return fmt.Errorf("wstat: %w", err)
+	}
 
-type dir struct {
-	Node
-	Dir
-	*stat.Reader
+	s.versions.bump(st.Qid.Path)
+
+	// the fids in the renamed file are moved with it
+	if sc.Has(StatName) {
+		pfd := fd.walk("..")
+		if parent, err := s.walkfd(ctx, pfd); err == nil {
+			s.touch(ctx, parent, pfd.aname, pfd.curPath())
+		}
+
+		s.renamed(fd, fd.curPath(), childPath(pfd.curPath(), sc.Name))
+	}
+
+	return nil
 }
 
-func (s *server) newDir(n Node, d Dir, path []string) (*dir, error) {
-	cs, err := d.Children()
-	if err != nil {
-		return nil, fmt.Errorf("children: %w", err)
+func (s *server) attach(ctx context.Context, m message.TAttach) (*message.RAttach, error) {
+	if s.fids.Get(m.Fid) != nil {
+		return nil, ErrDupFid
+	}
+
+	if err := s.checkAuth(m.Afid, m.Uname, m.Aname); err != nil {
+		return nil, err
 	}
-	for i := range cs {
-		if err = s.fillstat(&cs[i], false, path...); err != nil {
+
+	root := s.root
+	if s.attachFn != nil {
+		var err error
+		if root, err = s.attachFn(m.Uname, m.Aname); err != nil {
 			return nil, err
 		}
 	}
 
-	r := stat.NewReader(cs...)
-	return &dir{
-		Node:   n,
-		Dir:    d,
-		Reader: r,
+	st, err := StatNode(ctx, root)
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+
+	if err = s.fillstat(m.Aname, root, &st, true); err != nil {
+		return nil, err
+	}
+
+	s.fids.Set(m.Fid, newfd(root, m.Uname, m.Aname))
+	return &message.RAttach{
+		Qid: st.Qid,
 	}, nil
 }
 
-// func (s *server) close() error {}
-
 type fd struct {
-	path []string
+	// root is the root of the tree the fd belongs to, it's walked to
+	// get to path. path is guarded by pmu once the fd is in the fid table,
+	// as renames change it, see curPath.
+	root  Node
+	pmu   sync.Mutex
+	path  []string
+	uname string
+
+	// aname is the tree the fd was attached to, qid paths are allocated
+	// per tree
+	aname string
 
-	mu   sync.Mutex
+	// auth is set if the fd is an auth fid
+	auth *authFile
+
+	// refs are the Referencers along path, nil for the other Nodes
+	refs []Referencer
+
+	mu   fdMutex
 	open Node
+	mode OpenMode
+
+	// listing is the 9P2000.L listing of a directory opened by an Opener
+	listing *dir
+
+	// done is closed when the fid is clunked, to interrupt its requests
+	doneOnce sync.Once
+	done     chan struct{}
 }
 
-func newfd(path ...string) *fd {
-	return &fd{path: path}
+func newfd(root Node, uname, aname string) *fd {
+	return &fd{root: root, uname: uname, aname: aname}
+}
+
+// curPath returns the path of the fd. It changes when the file, or a
+// directory above it, is renamed; the slice itself isn't changed.
+func (f *fd) curPath() []string {
+	f.pmu.Lock()
+	defer f.pmu.Unlock()
+	return f.path
 }
 
 func (f *fd) walk(path ...string) *fd {
+	cur := f.curPath()
 	if len(path) == 0 {
-		return newfd(f.path...)
+		return &fd{root: f.root, path: cur, uname: f.uname, aname: f.aname}
 	}
 
-	p := make([]string, 0, len(f.path)+len(path))
-	p = append(p, f.path...)
+	p := make([]string, 0, len(cur)+len(path))
+	p = append(p, cur...)
 	for _, name := range path {
 		if name != ".." {
 			p = append(p, name)
@@ -367,5 +749,195 @@ func (f *fd) walk(path ...string) *fd {
 			p = p[:len(p)-1]
 		}
 	}
-	return newfd(p...)
+	return &fd{root: f.root, path: p, uname: f.uname, aname: f.aname}
+}
+
+// doneChan returns the channel that is closed when the fid is clunked.
+func (f *fd) doneChan() chan struct{} {
+	f.doneOnce.Do(func() { f.done = make(chan struct{}) })
+	return f.done
+}
+
+// clunked interrupts the requests of the fid.
+func (f *fd) clunked() {
+	done := f.doneChan()
+	select {
+	case <-done:
+	default:
+		close(done)
+	}
+}
+
+// context returns ctx, canceled when the fid is clunked. Requests that block,
+// like reads of a Stream, hold mu until they return, a clunk interrupts them
+// through ctx.
+func (f *fd) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := f.doneChan()
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// fdMutex is a mutex that can be given up on, for requests that are flushed
+// while they wait for a blocked one. The zero value is unlocked.
+type fdMutex struct {
+	once sync.Once
+	ch   chan struct{}
+}
+
+func (m *fdMutex) init() {
+	m.once.Do(func() { m.ch = make(chan struct{}, 1) })
+}
+
+func (m *fdMutex) Lock() {
+	m.init()
+	m.ch <- struct{}{}
+}
+
+// LockContext locks m, unless ctx is done first.
+func (m *fdMutex) LockContext(ctx context.Context) error {
+	m.init()
+	select {
+	case m.ch <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err() //nolint:wrapcheck
+	}
+}
+
+func (m *fdMutex) Unlock() {
+	<-m.ch
+}
+
+// ref adds the references of fd to its Referencers.
+func (f *fd) ref() {
+	for _, r := range f.refs {
+		if r != nil {
+			r.Ref()
+		}
+	}
+}
+
+// unref drops the references of fd, in reverse order.
+func (f *fd) unref() {
+	for i := len(f.refs) - 1; i >= 0; i-- {
+		if r := f.refs[i]; r != nil {
+			r.Unref()
+		}
+	}
+}
+
+// referencer returns node as a Referencer, or nil if it isn't one.
+func referencer(node Node) Referencer { //nolint:ireturn
+	r, _ := UnwrapValue[Referencer](node)
+	return r
+}
+
+// fidMap maps fids to fds.
+type fidMap struct {
+	mu sync.RWMutex
+	m  map[uint32]*fd
+}
+
+func newFidMap() *fidMap {
+	return &fidMap{m: map[uint32]*fd{}}
+}
+
+func (fm *fidMap) Get(fid uint32) *fd {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+	return fm.m[fid]
+}
+
+func (fm *fidMap) Set(fid uint32, fd *fd) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	fm.m[fid] = fd
+}
+
+// move changes the paths of the fds of root that are at oldpath or below it,
+// to newpath.
+func (fm *fidMap) move(root Node, oldpath, newpath []string) {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+
+	for _, fd := range fm.m {
+		if fd.auth != nil || !sameNode(fd.root, root) {
+			continue
+		}
+
+		fd.pmu.Lock()
+		if len(fd.path) >= len(oldpath) && samePath(fd.path[:len(oldpath)], oldpath) {
+			fd.path = append(append(make([]string, 0, len(newpath)+len(fd.path)-len(oldpath)), newpath...), fd.path[len(oldpath):]...)
+		}
+		fd.pmu.Unlock()
+	}
+}
+
+// Delete removes fid, returning its fd.
+func (fm *fidMap) Delete(fid uint32) *fd {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	fd := fm.m[fid]
+	delete(fm.m, fid)
+	return fd
+}
+
+// Clear removes all fids, returning their fds.
+func (fm *fidMap) Clear() []*fd {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	fds := make([]*fd, 0, len(fm.m))
+	for fid, fd := range fm.m {
+		fds = append(fds, fd)
+		delete(fm.m, fid)
+	}
+	return fds
+}
+
+// StatNode returns the Stat of node, with StatContext if node or a value it
+// wraps is a ContextStater.
+func StatNode(ctx context.Context, node Node) (Stat, error) {
+	if cs, ok := UnwrapValue[ContextStater](node); ok {
+		return cs.StatContext(ctx) //nolint:wrapcheck
+	}
+	return node.Stat() //nolint:wrapcheck
+}
+
+// WalkDir walks from dir to its child name, with WalkContext if dir or a
+// value it wraps is a ContextDir.
+func WalkDir(ctx context.Context, dir Dir, name string) (Node, error) { //nolint:ireturn
+	if cd, ok := UnwrapValue[ContextDir](dir); ok {
+		return cd.WalkContext(ctx, name) //nolint:wrapcheck
+	}
+	return dir.Walk(name) //nolint:wrapcheck
+}
+
+// DirChildren returns the children of dir, with ChildrenContext if dir or a
+// value it wraps is a ContextDir.
+func DirChildren(ctx context.Context, dir Dir) ([]Stat, error) {
+	if cd, ok := UnwrapValue[ContextDir](dir); ok {
+		return cd.ChildrenContext(ctx) //nolint:wrapcheck
+	}
+	return dir.Children() //nolint:wrapcheck
+}
+
+// openNode opens node if it's an Opener or a ContextOpener, ok is false
+// otherwise.
+func openNode(ctx context.Context, node Node, mode OpenMode) (val any, iounit uint32, ok bool, err error) {
+	if o, ok := UnwrapValue[ContextOpener](node); ok {
+		val, iounit, err = o.OpenContext(ctx, mode)
+		return val, iounit, true, err //nolint:wrapcheck
+	}
+	if o, ok := UnwrapValue[Opener](node); ok {
+		val, iounit, err = o.Open(mode)
+		return val, iounit, true, err //nolint:wrapcheck
+	}
+	return nil, 0, false, nil
 }
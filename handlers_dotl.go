@@ -0,0 +1,453 @@
+package np
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"go.rbn.im/neinp/message"
+)
+
+// unameOf returns the user name used in a 9P2000.u or 9P2000.L attach.
+func unameOf(uname string, nuname uint32) string {
+	if uname == "" && nuname != noUid {
+		return strconv.FormatUint(uint64(nuname), 10)
+	}
+	return uname
+}
+
+func (s *server) lopen(ctx context.Context, m *tlopen) (*rlopen, error) {
+	ro, err := s.open(ctx, message.TOpen{Fid: m.Fid, Mode: lopenMode(m.Flags)})
+	if err != nil {
+		return nil, err
+	}
+
+	return &rlopen{Qid: ro.Qid, Iounit: ro.Iounit}, nil
+}
+
+func (s *server) lcreate(ctx context.Context, m *tlcreate) (*rlcreate, error) {
+	fd := s.fids.Get(m.Fid)
+	if fd == nil {
+		return nil, ErrUnknownFid
+	}
+
+	perm, err := s.createPerm(ctx, fd, fromUnixMode(m.Mode)&^ModeDir)
+	if err != nil {
+		return nil, err
+	}
+
+	qid, iounit, err := s.createfd(ctx, m.Fid, m.Name, perm, lopenMode(m.Flags), "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &rlcreate{rlopen{Qid: qid, Iounit: iounit}}, nil
+}
+
+func (s *server) getattr(ctx context.Context, m *tgetattr) (*rgetattr, error) {
+	fd := s.fids.Get(m.Fid)
+	if fd == nil {
+		return nil, ErrUnknownFid
+	}
+
+	node, err := s.walkfd(ctx, fd)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := StatNode(ctx, node)
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+
+	if err = s.fillstat(fd.aname, node, &st, false, fd.curPath()...); err != nil {
+		return nil, err
+	}
+
+	us, err := nodeUnixStat(node, &st)
+	if err != nil {
+		return nil, fmt.Errorf("unix stat: %w", err)
+	}
+
+	const blksize = 4096
+	nlink := uint64(1)
+	if st.IsDir() {
+		nlink = 2
+	}
+
+	return &rgetattr{
+		Valid:   getattrBasic,
+		Qid:     st.Qid,
+		Mode:    unixMode(st.Mode),
+		Uid:     us.Uid,
+		Gid:     us.Gid,
+		Nlink:   nlink,
+		Size:    st.Length,
+		Blksize: blksize,
+		Blocks:  (st.Length + 511) / 512,
+		Atime:   st.Atime,
+		Mtime:   st.Mtime,
+		Ctime:   st.Mtime,
+	}, nil
+}
+
+func (s *server) setattr(ctx context.Context, m *tsetattr) (*rsetattr, error) {
+	fd := s.fids.Get(m.Fid)
+	if fd == nil {
+		return nil, ErrUnknownFid
+	}
+
+	var sc StatChanges
+	if m.Valid&setattrMode != 0 {
+		// the rest of the mode is set once the file is known
+		sc.Fields |= StatMode
+		sc.Mode = fromUnixMode(m.Mode) & chmodBits
+	}
+	if m.Valid&setattrUid != 0 {
+		sc.Fields |= StatUid
+		sc.Uid = strconv.FormatUint(uint64(m.Uid), 10)
+	}
+	if m.Valid&setattrGid != 0 {
+		sc.Fields |= StatGid
+		sc.Gid = strconv.FormatUint(uint64(m.Gid), 10)
+	}
+	if m.Valid&setattrSize != 0 {
+		sc.Fields |= StatLength
+		sc.Length = m.Size
+	}
+
+	now := time.Now()
+	if m.Valid&setattrAtime != 0 {
+		sc.Fields |= StatAtime
+		sc.Atime = now
+		if m.Valid&setattrAtimeSet != 0 {
+			sc.Atime = m.Atime
+		}
+	}
+	if m.Valid&setattrMtime != 0 {
+		sc.Fields |= StatMtime
+		sc.Mtime = now
+		if m.Valid&setattrMtimeSet != 0 {
+			sc.Mtime = m.Mtime
+		}
+	}
+
+	// only ctime was changed, nothing to do
+	if sc.Fields == 0 {
+		return &rsetattr{}, nil
+	}
+
+	node, err := s.walkfd(ctx, fd)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := StatNode(ctx, node)
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+
+	if err = s.fillstat(fd.aname, node, &st, false, fd.curPath()...); err != nil {
+		return nil, err
+	}
+
+	// chmod(2) doesn't change the type of the file
+	if sc.Has(StatMode) {
+		sc.Mode |= st.Mode &^ chmodBits
+	}
+
+	if err = s.checkWstat(ctx, fd, &st, sc); err != nil {
+		return nil, err
+	}
+
+	ws, ok := UnwrapValue[Wstater](node)
+	if !ok {
+		return nil, ErrNoWstat
+	}
+
+	if err = ws.Wstat(sc); err != nil {
+		return nil, fmt.Errorf("wstat: %w", err)
+	}
+
+	s.versions.bump(st.Qid.Path)
+	return &rsetattr{}, nil
+}
+
+func (s *server) readdir(ctx context.Context, m *treaddir) (*rreaddir, error) {
+	fd := s.fids.Get(m.Fid)
+	if fd == nil {
+		return nil, ErrUnknownFid
+	}
+
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	d, err := s.listing(ctx, fd)
+	if err != nil {
+		return nil, err
+	}
+
+	count := int(m.Count)
+	if max := int(s.maxCount()); count > max {
+		count = max
+	}
+
+	data, err := d.readdir(ctx, m.Offset, count)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rreaddir{Data: data}, nil
+}
+
+// listing returns the listing of the directory fd has opened. Directories
+// that were opened by an Opener are listed through their Dir, the listing is
+// kept until the fid is clunked. fd.mu must be held.
+func (s *server) listing(ctx context.Context, fd *fd) (*dir, error) {
+	if d, ok := UnwrapValue[*dir](fd.open); ok {
+		return d, nil
+	}
+	if fd.listing != nil {
+		return fd.listing, nil
+	}
+
+	if fd.open == nil {
+		return nil, ErrBadFid
+	}
+	node, err := s.walkfd(ctx, fd)
+	if err != nil {
+		return nil, err
+	}
+	dd, ok := UnwrapValue[Dir](node)
+	if !ok {
+		return nil, ErrNotDir
+	}
+
+	d, err := s.newDir(ctx, node, dd, fd.aname, fd.curPath())
+	if err != nil {
+		return nil, err
+	}
+	fd.listing = d
+	return d, nil
+}
+
+func (s *server) mkdir(ctx context.Context, m *tmkdir) (*rmkdir, error) {
+	fd := s.fids.Get(m.Dfid)
+	if fd == nil {
+		return nil, ErrUnknownFid
+	}
+
+	perm, err := s.createPerm(ctx, fd, fromUnixMode(m.Mode)&(ModePerm|ModeSetgid)|ModeDir)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := s.createChild(ctx, fd, m.Name, perm, ORead, "")
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := StatNode(ctx, node)
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+
+	if err = s.fillstat(fd.aname, node, &st, true, fd.walk(m.Name).path...); err != nil {
+		return nil, err
+	}
+
+	return &rmkdir{Qid: st.Qid}, nil
+}
+
+func (s *server) unlinkat(ctx context.Context, m *tunlinkat) (*runlinkat, error) {
+	fd := s.fids.Get(m.Dfid)
+	if fd == nil {
+		return nil, ErrUnknownFid
+	}
+
+	parent, err := s.walkfd(ctx, fd)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, ok := UnwrapValue[Dir](parent)
+	if !ok {
+		return nil, ErrNotDir
+	}
+
+	node, err := WalkDir(ctx, dir, m.Name)
+	if err != nil {
+		return nil, fmt.Errorf("walk: %w", err)
+	}
+
+	st, err := StatNode(ctx, node)
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+
+	if m.Flags&atRemovedir != 0 && !st.IsDir() {
+		return nil, ErrNotDir
+	}
+	if m.Flags&atRemovedir == 0 && st.IsDir() {
+		return nil, ErrIsDir
+	}
+
+	if err = s.checkPerm(ctx, parent, fd.aname, fd.curPath(), fd.uname, permWrite); err != nil {
+		return nil, err
+	}
+
+	if err = s.removeNode(parent, node, m.Name); err != nil {
+		return nil, err
+	}
+
+	s.removed(fd.aname, childPath(fd.curPath(), m.Name))
+	s.touch(ctx, parent, fd.aname, fd.curPath())
+	return &runlinkat{}, nil
+}
+
+func (s *server) renameat(ctx context.Context, m *trenameat) (*rrenameat, error) {
+	ofd := s.fids.Get(m.Olddfid)
+	nfd := s.fids.Get(m.Newdfid)
+	if ofd == nil || nfd == nil {
+		return nil, ErrUnknownFid
+	}
+
+	if !validName(m.Newname) {
+		return nil, ErrIllegalName
+	}
+
+	odir, err := s.walkfd(ctx, ofd)
+	if err != nil {
+		return nil, err
+	}
+
+	ndir, err := s.walkfd(ctx, nfd)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.checkPerm(ctx, odir, ofd.aname, ofd.curPath(), ofd.uname, permWrite); err != nil {
+		return nil, err
+	}
+	if err = s.checkPerm(ctx, ndir, nfd.aname, nfd.curPath(), ofd.uname, permWrite); err != nil {
+		return nil, err
+	}
+
+	if r, ok := UnwrapValue[Renamer](odir); ok {
+		if err = r.Rename(m.Oldname, ndir, m.Newname); err != nil {
+			return nil, fmt.Errorf("rename: %w", err)
+		}
+
+		s.renamed(ofd, childPath(ofd.curPath(), m.Oldname), childPath(nfd.curPath(), m.Newname))
+		s.touch(ctx, odir, ofd.aname, ofd.curPath())
+		s.touch(ctx, ndir, nfd.aname, nfd.curPath())
+		return &rrenameat{}, nil
+	}
+
+	if !sameNode(ofd.root, nfd.root) || !samePath(ofd.curPath(), nfd.curPath()) {
+		return nil, ErrCrossDevice
+	}
+
+	dir, ok := UnwrapValue[Dir](odir)
+	if !ok {
+		return nil, ErrNotDir
+	}
+
+	node, err := WalkDir(ctx, dir, m.Oldname)
+	if err != nil {
+		return nil, fmt.Errorf("walk: %w", err)
+	}
+
+	ws, ok := UnwrapValue[Wstater](node)
+	if !ok {
+		return nil, ErrNoWstat
+	}
+
+	var sc StatChanges
+	sc.Fields = StatName
+	sc.Name = m.Newname
+	if err = ws.Wstat(sc); err != nil {
+		return nil, fmt.Errorf("wstat: %w", err)
+	}
+
+	s.renamed(ofd, childPath(ofd.curPath(), m.Oldname), childPath(ofd.curPath(), m.Newname))
+	s.touch(ctx, odir, ofd.aname, ofd.curPath())
+	return &rrenameat{}, nil
+}
+
+func (s *server) statfs(ctx context.Context, m *tstatfs) (*rstatfs, error) {
+	fd := s.fids.Get(m.Fid)
+	if fd == nil {
+		return nil, ErrUnknownFid
+	}
+
+	node, err := s.walkfd(ctx, fd)
+	if err != nil {
+		return nil, err
+	}
+
+	if sf, ok := UnwrapValue[StatFSer](node); ok {
+		fst, err := sf.StatFS()
+		if err != nil {
+			return nil, fmt.Errorf("statfs: %w", err)
+		}
+		return &rstatfs{FSStat: fst}, nil
+	}
+
+	const v9fsMagic = 0x01021997
+	return &rstatfs{FSStat: FSStat{
+		Type:    v9fsMagic,
+		Bsize:   4096,
+		Namelen: 255,
+	}}, nil
+}
+
+func (s *server) fsync(ctx context.Context, m *tfsync) (*rfsync, error) {
+	fd := s.fids.Get(m.Fid)
+	if fd == nil {
+		return nil, ErrUnknownFid
+	}
+
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	var err error
+	node := fd.open
+	if node == nil {
+		if node, err = s.walkfd(ctx, fd); err != nil {
+			return nil, err
+		}
+	}
+
+	if sy, ok := UnwrapValue[Syncer](node); ok {
+		if err = sy.Sync(); err != nil {
+			return nil, fmt.Errorf("sync: %w", err)
+		}
+	}
+
+	return &rfsync{}, nil
+}
+
+// sameNode returns true if a and b are the same Node, Nodes that can't be
+// compared are assumed to be the same if they have the same type.
+func sameNode(a, b Node) bool {
+	ta, tb := reflect.TypeOf(a), reflect.TypeOf(b)
+	if ta != tb {
+		return false
+	}
+	return ta == nil || !ta.Comparable() || a == b
+}
+
+func samePath(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
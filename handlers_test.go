@@ -0,0 +1,107 @@
+package np_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/noonien/np"
+	"github.com/noonien/np/client"
+	"github.com/noonien/np/nptest"
+	"github.com/noonien/np/ramfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWstatRenameMovesFids(t *testing.T) {
+	t.Parallel()
+
+	rfs := ramfs.New()
+	require.Nil(t, rfs.MkdirAll("d/sub", 0o777))
+	require.Nil(t, rfs.WriteFile("d/sub/f", []byte("data"), 0o666))
+
+	c := nptest.ServePipe(t, rfs.Root())
+	ctx := context.Background()
+
+	d, err := c.Root.Walk(ctx, "d")
+	require.Nil(t, err)
+	f, err := c.Root.Walk(ctx, "d", "sub", "f")
+	require.Nil(t, err)
+
+	st := client.DontTouch()
+	st.Name = "e"
+	require.Nil(t, d.Wstat(ctx, st))
+
+	// fids in the renamed directory follow it
+	fst, err := f.Stat(ctx)
+	require.Nil(t, err)
+	require.Equal(t, "f", fst.Name)
+	require.Nil(t, f.Open(ctx, 0))
+	buf := make([]byte, 8)
+	n, err := f.Read(ctx, buf, 0)
+	require.Nil(t, err)
+	require.Equal(t, "data", string(buf[:n]))
+
+	g, err := d.Walk(ctx, "sub", "f")
+	require.Nil(t, err)
+	require.Equal(t, f.Qid().Path, g.Qid().Path)
+}
+
+func TestWstatRenameConcurrent(t *testing.T) {
+	t.Parallel()
+
+	rfs := ramfs.New()
+	require.Nil(t, rfs.WriteFile("f0", nil, 0o666))
+
+	c := nptest.ServePipe(t, rfs.Root())
+	ctx := context.Background()
+
+	f, err := c.Root.Walk(ctx, "f0")
+	require.Nil(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= 50; i++ {
+			st := client.DontTouch()
+			st.Name = "f" + strconv.Itoa(i)
+			assert.Nil(t, f.Wstat(ctx, st))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			_, err := f.Stat(ctx)
+			assert.Nil(t, err)
+		}
+	}()
+	wg.Wait()
+
+	st, err := f.Stat(ctx)
+	require.Nil(t, err)
+	require.Equal(t, "f50", st.Name)
+}
+
+func TestReadDirCount(t *testing.T) {
+	t.Parallel()
+
+	rfs := ramfs.New()
+	require.Nil(t, rfs.WriteFile("f", nil, 0o666))
+
+	c := nptest.ServePipe(t, rfs.Root())
+	ctx := context.Background()
+
+	d, err := c.Root.Walk(ctx)
+	require.Nil(t, err)
+	require.Nil(t, d.Open(ctx, np.ORead))
+
+	// entries are not split, the first one must fit
+	_, err = d.Read(ctx, make([]byte, 8), 0)
+	require.ErrorIs(t, err, np.ErrBadCount)
+
+	sts, _, err := d.ReadDir(ctx, 0)
+	require.Nil(t, err)
+	require.Len(t, sts, 1)
+}
@@ -0,0 +1,362 @@
+// Package iofs serves io/fs file systems.
+//
+// File systems are read-only, unless they implement OpenFileFS, MkdirFS or
+// RemoveFS.
+package iofs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/noonien/np"
+)
+
+// OpenFileFS is a file system that can open files for writing and create
+// them.
+type OpenFileFS interface {
+	fs.FS
+
+	// OpenFile opens name with flag and perm, like os.OpenFile. The
+	// returned file should implement io.WriterAt or io.Writer if it was
+	// opened for writing.
+	OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error)
+}
+
+// MkdirFS is a file system that can create directories.
+type MkdirFS interface {
+	fs.FS
+
+	// Mkdir creates the directory name, like os.Mkdir.
+	Mkdir(name string, perm fs.FileMode) error
+}
+
+// RemoveFS is a file system that can remove files.
+type RemoveFS interface {
+	fs.FS
+
+	// Remove removes the file or empty directory name, like os.Remove.
+	Remove(name string) error
+}
+
+// Owner can be implemented by the Sys value of a fs.FileInfo to set the owner
+// of a file.
+type Owner interface {
+	Owner() (uid, gid string)
+}
+
+// New returns the root directory of fsys.
+//
+// Files are opened with the Open method of fsys when they are opened for
+// reading, the returned fs.File is used as is, so it should implement
+// io.ReaderAt if it supports random access.
+func New(fsys fs.FS) np.Node { //nolint:ireturn
+	return &dir{node{fsys: fsys, name: "."}}
+}
+
+// newNode returns the Node of the file name.
+func newNode(fsys fs.FS, name string) (np.Node, error) { //nolint:ireturn
+	fi, err := fs.Stat(fsys, name)
+	if err != nil {
+		return nil, mapErr(err)
+	}
+
+	n := node{fsys: fsys, name: name}
+	if fi.IsDir() {
+		return &dir{n}, nil
+	}
+	return &file{n}, nil
+}
+
+// node is the common part of files and directories.
+type node struct {
+	fsys fs.FS
+	name string
+}
+
+func (n *node) Stat() (np.Stat, error) {
+	fi, err := fs.Stat(n.fsys, n.name)
+	if err != nil {
+		return np.Stat{}, mapErr(err)
+	}
+	return toStat(fi, n.name), nil
+}
+
+func (n *node) Remove() error {
+	rfs, ok := n.fsys.(RemoveFS)
+	if !ok {
+		return np.ErrNoRemove
+	}
+	return mapErr(rfs.Remove(n.name))
+}
+
+// file is a file of a fs.FS.
+type file struct {
+	node
+}
+
+var (
+	_ np.Opener  = &file{}
+	_ np.Remover = &file{}
+)
+
+func (f *file) Open(mode np.OpenMode) (any, uint32, error) {
+	flag := openFlag(mode)
+	if flag == os.O_RDONLY {
+		fl, err := f.fsys.Open(f.name)
+		if err != nil {
+			return nil, 0, mapErr(err)
+		}
+		return fl, 0, nil
+	}
+
+	ofs, ok := f.fsys.(OpenFileFS)
+	if !ok {
+		return nil, 0, np.ErrReadOnlyFS
+	}
+
+	fl, err := ofs.OpenFile(f.name, flag, 0)
+	if err != nil {
+		return nil, 0, mapErr(err)
+	}
+	return fl, 0, nil
+}
+
+// created is a file that was just created, its first Open returns the file
+// opened by Create, which might not be allowed to be opened again.
+type created struct {
+	*file
+	f fs.File
+}
+
+func (c *created) Open(mode np.OpenMode) (any, uint32, error) {
+	if f := c.f; f != nil {
+		c.f = nil
+		return f, 0, nil
+	}
+	return c.file.Open(mode)
+}
+
+// dir is a directory of a fs.FS.
+type dir struct {
+	node
+}
+
+var (
+	_ np.Dir       = &dir{}
+	_ np.DirLister = &dir{}
+	_ np.Creator   = &dir{}
+	_ np.Remover   = &dir{}
+)
+
+func (d *dir) Children() ([]np.Stat, error) {
+	ents, err := fs.ReadDir(d.fsys, d.name)
+	if err != nil {
+		return nil, mapErr(err)
+	}
+	return d.stats(ents)
+}
+
+// stats returns the stats of the entries ents of d.
+func (d *dir) stats(ents []fs.DirEntry) ([]np.Stat, error) {
+	sts := make([]np.Stat, 0, len(ents))
+	for _, ent := range ents {
+		fi, err := ent.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// removed since it was listed
+			continue
+		} else if err != nil {
+			return nil, mapErr(err)
+		}
+
+		sts = append(sts, toStat(fi, path.Join(d.name, ent.Name())))
+	}
+	return sts, nil
+}
+
+func (d *dir) Walk(name string) (np.Node, error) { //nolint:ireturn
+	if !validName(name) {
+		return nil, np.ErrNotFound
+	}
+	return newNode(d.fsys, path.Join(d.name, name))
+}
+
+// List lists the directory with fs.ReadDirFile, if the opened directory
+// implements it.
+func (d *dir) List(ctx context.Context) (np.DirIterator, error) { //nolint:ireturn
+	f, err := d.fsys.Open(d.name)
+	if err != nil {
+		return nil, mapErr(err)
+	}
+
+	rd, ok := f.(fs.ReadDirFile)
+	if !ok {
+		f.Close()
+		sts, err := d.Children()
+		if err != nil {
+			return nil, err
+		}
+		return &statIterator{stats: sts}, nil
+	}
+
+	return &dirIterator{d: d, f: rd}, nil
+}
+
+func (d *dir) Create(name string, perm np.Mode, mode np.OpenMode) (np.Node, error) { //nolint:ireturn
+	if !validName(name) {
+		return nil, np.ErrIllegalName
+	}
+
+	cname := path.Join(d.name, name)
+	fperm := np.FileMode(perm).Perm()
+
+	if perm&np.ModeDir != 0 {
+		mfs, ok := d.fsys.(MkdirFS)
+		if !ok {
+			return nil, np.ErrNoCreate
+		}
+		if err := mfs.Mkdir(cname, fperm); err != nil {
+			return nil, mapErr(err)
+		}
+		return &dir{node{fsys: d.fsys, name: cname}}, nil
+	}
+
+	ofs, ok := d.fsys.(OpenFileFS)
+	if !ok {
+		return nil, np.ErrNoCreate
+	}
+
+	f, err := ofs.OpenFile(cname, os.O_CREATE|os.O_EXCL|openFlag(mode), fperm)
+	if err != nil {
+		return nil, mapErr(err)
+	}
+
+	return &created{
+		file: &file{node{fsys: d.fsys, name: cname}},
+		f:    f,
+	}, nil
+}
+
+// dirIterator lists a directory with fs.ReadDirFile.
+type dirIterator struct {
+	d *dir
+	f fs.ReadDirFile
+}
+
+func (it *dirIterator) Next(ctx context.Context, n int) ([]np.Stat, error) {
+	if n <= 0 {
+		n = 1
+	}
+
+	ents, err := it.f.ReadDir(n)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, mapErr(err)
+	}
+
+	sts, serr := it.d.stats(ents)
+	if serr != nil {
+		return nil, serr
+	}
+	return sts, err //nolint:wrapcheck // io.EOF
+}
+
+func (it *dirIterator) Close() error {
+	return it.f.Close() //nolint:wrapcheck
+}
+
+// statIterator is a DirIterator over a complete listing.
+type statIterator struct {
+	stats []np.Stat
+}
+
+func (it *statIterator) Next(ctx context.Context, n int) ([]np.Stat, error) {
+	sts := it.stats
+	it.stats = nil
+	return sts, io.EOF
+}
+
+// validName returns true if name is a single path element.
+func validName(name string) bool {
+	return name != "." && !strings.Contains(name, "/") && fs.ValidPath(name)
+}
+
+// openFlag returns the os.OpenFile flag for mode.
+func openFlag(mode np.OpenMode) int {
+	var flag int
+	switch mode & 3 {
+	case np.ORead, np.OExec:
+		flag = os.O_RDONLY
+	case np.OWrite:
+		flag = os.O_WRONLY
+	case np.ORdwr:
+		flag = os.O_RDWR
+	}
+
+	if mode&np.OTrunc != 0 {
+		flag |= os.O_TRUNC
+	}
+
+	return flag
+}
+
+// toStat converts the FileInfo of the file name.
+func toStat(fi fs.FileInfo, name string) np.Stat {
+	st := Stat(fi)
+	if name == "." {
+		st.Name = "/"
+	}
+	return st
+}
+
+// Stat converts fi to a Stat.
+//
+// The owner is set if the Sys value of fi is a *np.Stat, implements Owner or
+// is a stat from the host system.
+func Stat(fi fs.FileInfo) np.Stat {
+	st := np.Stat{
+		Name:   fi.Name(),
+		Mode:   np.ModeOf(fi.Mode()),
+		Length: uint64(fi.Size()),
+		Mtime:  fi.ModTime(),
+	}
+
+	if fi.IsDir() {
+		st.Length = 0
+	}
+
+	switch sys := fi.Sys().(type) {
+	case *np.Stat:
+		st.Atime = sys.Atime
+		st.Uid, st.Gid, st.Muid = sys.Uid, sys.Gid, sys.Muid
+	case Owner:
+		st.Uid, st.Gid = sys.Owner()
+	default:
+		st.Uid, st.Gid, _ = sysOwner(sys)
+	}
+
+	return st
+}
+
+// mapErr converts the fs errors to np errors.
+func mapErr(err error) error {
+	var ne np.Error
+	switch {
+	case err == nil, errors.As(err, &ne):
+		return err
+	case errors.Is(err, fs.ErrNotExist):
+		return np.ErrNotFound
+	case errors.Is(err, fs.ErrExist):
+		return np.ErrExists
+	case errors.Is(err, fs.ErrPermission):
+		return np.ErrPerm
+	case errors.Is(err, fs.ErrInvalid):
+		return np.ErrInvalidArg
+	case errors.Is(err, fs.ErrClosed):
+		return np.ErrBadFD
+	}
+	return err
+}
@@ -0,0 +1,27 @@
+package iofs_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/noonien/np"
+	"github.com/noonien/np/iofs"
+	"github.com/noonien/np/nptest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapFS(t *testing.T) {
+	t.Parallel()
+
+	fsys := fstest.MapFS{
+		"hello.txt":     {Data: []byte("hello world"), Mode: 0o644},
+		"dir/a":         {Data: []byte("a"), Mode: 0o600},
+		"dir/sub/b.txt": {Data: []byte("b"), Mode: 0o444},
+	}
+
+	// the files have no owner, the user "" doesn't own them
+	c := nptest.ServePipe(t, iofs.New(fsys), np.NoPermissions())
+
+	err := fstest.TestFS(c, "hello.txt", "dir/a", "dir/sub/b.txt")
+	require.Nil(t, err)
+}
@@ -0,0 +1,44 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package iofs
+
+import (
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// names caches user and group names by "u" or "g" and their id.
+var names sync.Map
+
+// sysOwner returns the owner of a host file, if sys is its stat.
+func sysOwner(sys any) (uid, gid string, ok bool) {
+	st, ok := sys.(*syscall.Stat_t)
+	if !ok {
+		return "", "", false
+	}
+
+	return lookupName("u", st.Uid), lookupName("g", st.Gid), true
+}
+
+// lookupName returns the name of a user or group, or its id if it has no
+// name.
+func lookupName(kind string, id uint32) string {
+	sid := strconv.FormatUint(uint64(id), 10)
+	if name, ok := names.Load(kind + sid); ok {
+		return name.(string) //nolint:forcetypeassert
+	}
+
+	name := sid
+	if kind == "u" {
+		if u, err := user.LookupId(sid); err == nil {
+			name = u.Username
+		}
+	} else if g, err := user.LookupGroupId(sid); err == nil {
+		name = g.Name
+	}
+
+	names.Store(kind+sid, name)
+	return name
+}
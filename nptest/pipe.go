@@ -0,0 +1,136 @@
+package nptest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/noonien/np"
+	"github.com/noonien/np/client"
+	"github.com/stretchr/testify/require"
+)
+
+// Conn is an in-process client connection to a served Node.
+//
+// Conn is an fs.FS of the served tree, paths are slash separated and
+// unrooted, as required by io/fs.
+type Conn struct {
+	*client.FS
+
+	Client *client.Client
+	Root   *client.Fid
+}
+
+// ServePipe serves a Node over net.Pipe to an in-process client, attached as
+// the user "". Unlike Serve, it doesn't need plan9port.
+//
+// The connection is closed at the end of the test.
+func ServePipe(t *testing.T, root np.Node, opts ...np.Option) *Conn {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sc, cc := net.Pipe()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		err := np.Serve(ctx, sc, root, opts...)
+		if err != nil && !closed(err) {
+			t.Errorf("serve: %v", err)
+		}
+	}()
+
+	c, err := client.NewClient(ctx, cc)
+	require.Nil(t, err)
+
+	t.Cleanup(func() {
+		c.Close()
+		cancel()
+		<-done
+	})
+
+	fid, err := c.Attach(ctx, nil, "", "")
+	require.Nil(t, err)
+
+	return &Conn{
+		FS:     client.NewFS(fid),
+		Client: c,
+		Root:   fid,
+	}
+}
+
+// closed returns true if err is caused by closing the connection.
+func closed(err error) bool {
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, context.Canceled)
+}
+
+// walk returns a new Fid for name.
+func (c *Conn) walk(ctx context.Context, name string) (*client.Fid, error) {
+	var names []string
+	if name = strings.Trim(name, "/"); name != "" && name != "." {
+		names = strings.Split(name, "/")
+	}
+	return c.Root.Walk(ctx, names...) //nolint:wrapcheck
+}
+
+// Create creates the file name with perm and writes data to it.
+//
+// Directories are created by setting np.ModeDir in perm, data is then
+// ignored.
+func (c *Conn) Create(name string, perm np.Mode, data []byte) error {
+	ctx := context.Background()
+
+	dir, err := c.walk(ctx, path.Dir(name))
+	if err != nil {
+		return err
+	}
+	defer dir.Clunk(ctx) //nolint:errcheck
+
+	mode := np.OWrite
+	if perm&np.ModeDir != 0 {
+		mode = np.ORead
+	}
+	if err = dir.Create(ctx, path.Base(name), perm, mode); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	if perm&np.ModeDir == 0 && len(data) > 0 {
+		if _, err = dir.Write(ctx, data, 0); err != nil {
+			return err //nolint:wrapcheck
+		}
+	}
+
+	return nil
+}
+
+// Remove removes the file name.
+func (c *Conn) Remove(name string) error {
+	ctx := context.Background()
+
+	f, err := c.walk(ctx, name)
+	if err != nil {
+		return err
+	}
+	return f.Remove(ctx) //nolint:wrapcheck
+}
+
+// Wstat changes the stat of name, see client.DontTouch.
+func (c *Conn) Wstat(name string, st np.Stat) error {
+	ctx := context.Background()
+
+	f, err := c.walk(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer f.Clunk(ctx) //nolint:errcheck
+
+	return f.Wstat(ctx, st) //nolint:wrapcheck
+}
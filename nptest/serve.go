@@ -16,6 +16,9 @@ import (
 
 // Serve mounts a Node at the returned mntpath, used for testing.
 //
+// Mounting needs the 9 command from plan9port, see ServePipe for a client that
+// runs in-process.
+//
 // The cleanup return function should be called at the end of the test to unmount and clean the files.
 func Serve(t *testing.T, root np.Node, opts ...np.Option) (string, func()) {
 	t.Helper()
@@ -0,0 +1,414 @@
+// Package ns implements namespaces, trees that are composed by binding Nodes
+// at paths, like Plan 9's bind(1).
+//
+// A path that Nodes are bound to is a union of them. Walking a name in a
+// union directory walks it in the members of the union, in order, and returns
+// the first file found. Listing a union directory lists the files of all
+// members, the first file with a name hides the others.
+package ns
+
+import (
+	"context"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/noonien/np"
+)
+
+// Flag sets how a Node is bound.
+type Flag int
+
+const (
+	// Replace replaces the file at the path with the bound Node.
+	Replace Flag = 0
+
+	// Before adds the bound Node to the union at the path, before its
+	// other members.
+	Before Flag = 1
+
+	// After adds the bound Node to the union at the path, after its other
+	// members.
+	After Flag = 2
+
+	// Create allows creating files in the bound Node. Files created in a
+	// union are created in the first member that allows it.
+	Create Flag = 4
+)
+
+// bind is a Node bound at a path.
+type bind struct {
+	node np.Node
+	flag Flag
+}
+
+// Namespace is a tree of bound Nodes.
+//
+// Bindings can be changed while the namespace is served, fids that point
+// into the namespace see the changes on their next request.
+type Namespace struct {
+	mu sync.RWMutex
+
+	// binds are the bindings at each path, in the order they were made.
+	// Paths are cleaned and unrooted, "" is the root.
+	binds map[string][]bind
+}
+
+// New returns an empty Namespace.
+func New() *Namespace {
+	return &Namespace{
+		binds: make(map[string][]bind),
+	}
+}
+
+// cleanPath returns the key of the slash separated path p.
+func cleanPath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+// Bind binds node at the path p.
+//
+// With Replace, node replaces the union at p, otherwise it's added before or
+// after its members. Bind p to a directory, before p's subdirectories can be
+// bound, is not needed: directories are made up for paths that lead to bound
+// Nodes.
+func (ns *Namespace) Bind(p string, node np.Node, flag Flag) error {
+	key := cleanPath(p)
+	if _, ok := np.UnwrapValue[np.Dir](node); !ok && key == "" {
+		return np.ErrNotDir
+	}
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	ns.binds[key] = append(ns.binds[key], bind{node: node, flag: flag})
+	return nil
+}
+
+// Unbind removes the bindings of node at the path p, or all the bindings at p
+// if node is nil.
+func (ns *Namespace) Unbind(p string, node np.Node) error {
+	key := cleanPath(p)
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	binds := ns.binds[key]
+	kept := binds[:0:0]
+	for _, b := range binds {
+		if node != nil && !sameNode(b.node, node) {
+			kept = append(kept, b)
+		}
+	}
+
+	if len(kept) == len(binds) {
+		return np.ErrNotFound
+	}
+
+	if len(kept) == 0 {
+		delete(ns.binds, key)
+	} else {
+		ns.binds[key] = kept
+	}
+	return nil
+}
+
+// sameNode returns true if a and b are the same Node.
+func sameNode(a, b np.Node) bool {
+	t := reflect.TypeOf(a)
+	return t == reflect.TypeOf(b) && t.Comparable() && a == b
+}
+
+// Root returns the root directory of the namespace.
+func (ns *Namespace) Root() np.Node { //nolint:ireturn
+	return &union{ns: ns}
+}
+
+// apply applies the bindings at key to the union members, which are the
+// files found at key without them.
+func (ns *Namespace) apply(key string, members []bind) []bind {
+	for _, b := range ns.binds[key] {
+		switch b.flag &^ Create {
+		case Before:
+			members = append([]bind{b}, members...)
+		case After:
+			members = append(members[:len(members):len(members)], b)
+		default:
+			members = []bind{b}
+		}
+	}
+	return members
+}
+
+// hasBinds returns true if Nodes are bound at key or below it.
+func (ns *Namespace) hasBinds(key string) bool {
+	if key == "" {
+		return len(ns.binds) > 0
+	}
+
+	for k := range ns.binds {
+		if k == key || strings.HasPrefix(k, key+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// boundChildren returns the names of the children of key that have Nodes
+// bound at or below them.
+func (ns *Namespace) boundChildren(key string) []string {
+	prefix := key + "/"
+	if key == "" {
+		prefix = ""
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	for k := range ns.binds {
+		if k == "" || !strings.HasPrefix(k, prefix) {
+			continue
+		}
+
+		name, _, _ := strings.Cut(k[len(prefix):], "/")
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// union is a directory of the namespace.
+type union struct {
+	ns *Namespace
+
+	// key is the path of the directory, members the Nodes that make it up,
+	// as of the walk to it
+	key     string
+	members []bind
+}
+
+// current returns the members of the union.
+//
+// The root is not walked to, so its members are looked up every time.
+func (u *union) current() []bind {
+	if u.key != "" {
+		return u.members
+	}
+
+	u.ns.mu.RLock()
+	defer u.ns.mu.RUnlock()
+	return u.ns.apply("", nil)
+}
+
+var (
+	_ np.Dir           = &union{}
+	_ np.ContextDir    = &union{}
+	_ np.ContextStater = &union{}
+	_ np.Creator       = &union{}
+	_ np.ChildRemover  = &union{}
+)
+
+func (u *union) name() string {
+	if u.key == "" {
+		return "/"
+	}
+	return path.Base(u.key)
+}
+
+// Stat returns the stat of the first member, if it's a directory.
+func (u *union) Stat() (np.Stat, error) {
+	return u.StatContext(context.Background())
+}
+
+func (u *union) StatContext(ctx context.Context) (np.Stat, error) {
+	st := np.Stat{Mode: np.ModeDir | 0o555}
+	if members := u.current(); len(members) > 0 {
+		if _, ok := np.UnwrapValue[np.Dir](members[0].node); ok {
+			mst, err := np.StatNode(ctx, members[0].node)
+			if err != nil {
+				return np.Stat{}, err //nolint:wrapcheck
+			}
+			st = mst
+		}
+	}
+
+	// the qid is given by the server, as the union is not the member
+	st.Qid = np.Qid{Type: np.QTDir}
+	st.Name = u.name()
+	return st, nil
+}
+
+func (u *union) Children() ([]np.Stat, error) {
+	return u.ChildrenContext(context.Background())
+}
+
+func (u *union) ChildrenContext(ctx context.Context) ([]np.Stat, error) {
+	var sts []np.Stat
+	seen := make(map[string]int)
+	add := func(st np.Stat) {
+		if i, ok := seen[st.Name]; ok {
+			sts[i] = st
+			return
+		}
+		seen[st.Name] = len(sts)
+		sts = append(sts, st)
+	}
+
+	for _, m := range u.dirs() {
+		cs, err := np.DirChildren(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+		for _, st := range cs {
+			if _, ok := seen[st.Name]; !ok {
+				add(st)
+			}
+		}
+	}
+
+	// children with bindings replace the files of the members
+	u.ns.mu.RLock()
+	names := u.ns.boundChildren(u.key)
+	u.ns.mu.RUnlock()
+
+	for _, name := range names {
+		// the binding can be gone since, with a concurrent Unbind
+		child, err := u.WalkContext(ctx, name)
+		if err != nil {
+			continue
+		}
+
+		st, err := np.StatNode(ctx, child)
+		if err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+		st.Name = name
+		add(st)
+	}
+
+	return sts, nil
+}
+
+func (u *union) Walk(name string) (np.Node, error) { //nolint:ireturn
+	return u.WalkContext(context.Background(), name)
+}
+
+func (u *union) WalkContext(ctx context.Context, name string) (np.Node, error) { //nolint:ireturn
+	if name == "" || strings.Contains(name, "/") {
+		return nil, np.ErrNotFound
+	}
+
+	// the file found without the bindings at the child, or the error of
+	// the first member if there is none
+	var found np.Node
+	var werr error
+	for _, m := range u.dirs() {
+		node, err := np.WalkDir(ctx, m, name)
+		if err == nil {
+			found = node
+			break
+		}
+		if werr == nil {
+			werr = err
+		}
+	}
+	if werr == nil {
+		werr = np.ErrNotFound
+	}
+
+	key := path.Join(u.key, name)
+
+	u.ns.mu.RLock()
+	defer u.ns.mu.RUnlock()
+
+	if !u.ns.hasBinds(key) {
+		if found == nil {
+			return nil, werr
+		}
+		return found, nil
+	}
+
+	var members []bind
+	if found != nil {
+		members = []bind{{node: found}}
+	}
+	members = u.ns.apply(key, members)
+
+	// a bound file, unless directories have to be made up below it
+	if len(members) > 0 && len(u.ns.boundChildren(key)) == 0 {
+		if _, ok := np.UnwrapValue[np.Dir](members[0].node); !ok {
+			return &renamed{Node: members[0].node, name: name}, nil
+		}
+	}
+
+	return &union{ns: u.ns, key: key, members: members}, nil
+}
+
+// dirs returns the members that are directories.
+func (u *union) dirs() []np.Dir {
+	var ds []np.Dir
+	for _, m := range u.current() {
+		if d, ok := np.UnwrapValue[np.Dir](m.node); ok {
+			ds = append(ds, d)
+		}
+	}
+	return ds
+}
+
+// Create creates the file in the first member that was bound with Create.
+func (u *union) Create(name string, perm np.Mode, mode np.OpenMode) (np.Node, error) { //nolint:ireturn
+	for _, m := range u.current() {
+		if m.flag&Create == 0 {
+			continue
+		}
+
+		c, ok := np.UnwrapValue[np.Creator](m.node)
+		if !ok {
+			return nil, np.ErrNoCreate
+		}
+		return c.Create(name, perm, mode) //nolint:wrapcheck
+	}
+
+	return nil, np.ErrNoCreate
+}
+
+// RemoveChild removes name from the first member that has it.
+func (u *union) RemoveChild(name string) error {
+	for _, m := range u.current() {
+		d, ok := np.UnwrapValue[np.Dir](m.node)
+		if !ok {
+			continue
+		}
+		if _, err := d.Walk(name); err != nil {
+			continue
+		}
+
+		cr, ok := np.UnwrapValue[np.ChildRemover](m.node)
+		if !ok {
+			return np.ErrNoRemove
+		}
+		return cr.RemoveChild(name) //nolint:wrapcheck
+	}
+
+	return np.ErrNotFound
+}
+
+// renamed is a bound file, named after the path it's bound to.
+type renamed struct {
+	np.Node
+	name string
+}
+
+func (r *renamed) Stat() (np.Stat, error) {
+	return r.StatContext(context.Background())
+}
+
+func (r *renamed) StatContext(ctx context.Context) (np.Stat, error) {
+	st, err := np.StatNode(ctx, r.Node)
+	st.Name = r.name
+	return st, err //nolint:wrapcheck
+}
+
+func (r *renamed) Unwrap() any { return r.Node }
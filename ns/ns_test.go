@@ -0,0 +1,127 @@
+package ns_test
+
+import (
+	"context"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/noonien/np"
+	"github.com/noonien/np/iofs"
+	"github.com/noonien/np/nptest"
+	"github.com/noonien/np/ns"
+	"github.com/noonien/np/ramfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnion(t *testing.T) {
+	t.Parallel()
+
+	a := iofs.New(fstest.MapFS{
+		"x": {Data: []byte("a"), Mode: 0o444},
+		"y": {Data: []byte("a"), Mode: 0o444},
+	})
+	b := iofs.New(fstest.MapFS{
+		"x": {Data: []byte("b"), Mode: 0o444},
+		"z": {Data: []byte("b"), Mode: 0o444},
+	})
+
+	n := ns.New()
+	require.Nil(t, n.Bind("/", a, ns.Replace))
+	require.Nil(t, n.Bind("/", b, ns.After))
+	require.Nil(t, n.Bind("/sub/b", b, ns.Replace))
+
+	c := nptest.ServePipe(t, n.Root())
+
+	err := fstest.TestFS(c, "x", "y", "z", "sub/b/x", "sub/b/z")
+	require.Nil(t, err)
+
+	data, err := fs.ReadFile(c, "x")
+	require.Nil(t, err)
+	require.Equal(t, "a", string(data))
+
+	// bindings can be changed while serving
+	require.Nil(t, n.Bind("/", b, ns.Before))
+	data, err = fs.ReadFile(c, "x")
+	require.Nil(t, err)
+	require.Equal(t, "b", string(data))
+
+	require.Nil(t, n.Unbind("/sub/b", nil))
+	_, err = fs.Stat(c, "sub")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestUnionCreate(t *testing.T) {
+	t.Parallel()
+
+	a, b := ramfs.New(), ramfs.New()
+	require.Nil(t, a.WriteFile("x", []byte("a"), 0o666))
+	require.Nil(t, b.WriteFile("y", []byte("b"), 0o666))
+
+	n := ns.New()
+	require.Nil(t, n.Bind("/", a.Root(), ns.Replace))
+	c := nptest.ServePipe(t, n.Root(), np.NoPermissions())
+
+	// no member allows creating files
+	require.ErrorIs(t, c.Create("new", 0o666, nil), np.ErrNoCreate)
+
+	// files are created in the first member bound with Create
+	require.Nil(t, n.Bind("/", b.Root(), ns.After|ns.Create))
+	require.Nil(t, c.Create("new", 0o666, []byte("data")))
+	data, err := b.ReadFile("new")
+	require.Nil(t, err)
+	require.Equal(t, "data", string(data))
+	_, err = a.Stat("new")
+	require.ErrorIs(t, err, np.ErrNotFound)
+
+	// removes go to the member that has the file
+	require.Nil(t, c.Remove("x"))
+	_, err = a.Stat("x")
+	require.ErrorIs(t, err, np.ErrNotFound)
+	require.Nil(t, c.Remove("y"))
+	_, err = b.Stat("y")
+	require.ErrorIs(t, err, np.ErrNotFound)
+}
+
+func TestUnbind(t *testing.T) {
+	t.Parallel()
+
+	a := iofs.New(fstest.MapFS{"x": {Data: []byte("a"), Mode: 0o444}})
+	b := iofs.New(fstest.MapFS{"y": {Data: []byte("b"), Mode: 0o444}})
+
+	n := ns.New()
+	require.Nil(t, n.Bind("/u", a, ns.Replace))
+	require.Nil(t, n.Bind("/u", b, ns.After))
+	c := nptest.ServePipe(t, n.Root())
+	require.Nil(t, fstest.TestFS(c, "u/x", "u/y"))
+
+	// only the bindings of the node are removed
+	require.Nil(t, n.Unbind("/u", b))
+	require.Nil(t, fstest.TestFS(c, "u/x"))
+	_, err := fs.Stat(c, "u/y")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	require.ErrorIs(t, n.Unbind("/u", b), np.ErrNotFound)
+}
+
+func TestBindBelowWalked(t *testing.T) {
+	t.Parallel()
+
+	a := iofs.New(fstest.MapFS{"x": {Data: []byte("a"), Mode: 0o444}})
+
+	n := ns.New()
+	require.Nil(t, n.Bind("/d/a", a, ns.Replace))
+	c := nptest.ServePipe(t, n.Root())
+
+	ctx := context.Background()
+	d, err := c.Root.Walk(ctx, "d")
+	require.Nil(t, err)
+
+	// bindings made below an already walked union are seen through it
+	require.Nil(t, n.Bind("/d/b", a, ns.Replace))
+	f, err := d.Walk(ctx, "b", "x")
+	require.Nil(t, err)
+	require.Nil(t, f.Clunk(ctx))
+	ents, err := fs.ReadDir(c, "d")
+	require.Nil(t, err)
+	require.Len(t, ents, 2)
+}
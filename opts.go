@@ -1,25 +1,144 @@
 package np
 
-import "time"
+import (
+	"io"
+	"time"
+)
 
-type Option func(*server)
+type Option func(*Server)
 
 func Msize(msize uint32) Option {
-	return func(s *server) {
+	return func(s *Server) {
 		s.msize = msize
 	}
 }
 
 func Debug(flags DebugFlags) Option {
-	return func(s *server) {
+	return func(s *Server) {
 		s.debug |= flags
 	}
 }
 
+// ConnError sets a function that is called with the error that ended a
+// connection accepted by Server.Serve.
+//
+// Connections that are closed by the client or by Shutdown are not reported.
+func ConnError(fn func(rwc io.ReadWriteCloser, err error)) Option {
+	return func(s *Server) {
+		s.connErr = fn
+	}
+}
+
+// Groups sets the GroupResolver that is used to check the group permissions
+// of files.
+//
+// By default, users are not a member of any group.
+func Groups(g GroupResolver) Option {
+	return func(s *Server) {
+		s.groups = g
+	}
+}
+
+// NoPermissions turns off permission checks, users can do anything the
+// Nodes allow.
+//
+// By default, the mode bits of files are checked against the attach user,
+// except for files that have neither permissions nor an owner.
+func NoPermissions() Option {
+	return func(s *Server) {
+		s.noPerms = true
+	}
+}
+
+// Auth requires clients to authenticate using a, before they can attach.
+//
+// See SharedSecret for a simple Authenticator.
+func Auth(a Authenticator) Option {
+	return func(s *Server) {
+		s.authn = a
+	}
+}
+
+// Attach sets a function that returns the root Node for an attach of uname
+// to aname. The root passed to NewServer is not used.
+//
+// Returning an error refuses the attach.
+func Attach(fn func(uname, aname string) (Node, error)) Option {
+	return func(s *Server) {
+		s.attachFn = fn
+	}
+}
+
+// Roots serves a different tree for every aname in roots. Attaches to other
+// anames fail with ErrBadAttach.
+//
+// The root passed to NewServer is used for the empty aname, unless roots has
+// an entry for it.
+func Roots(roots map[string]Node) Option {
+	return func(s *Server) {
+		root := s.root
+		s.attachFn = func(uname, aname string) (Node, error) {
+			if node, ok := roots[aname]; ok {
+				return node, nil
+			}
+			if aname == "" && root != nil {
+				return root, nil
+			}
+			return nil, ErrBadAttach
+		}
+	}
+}
+
+// MaxConnRequests limits the number of requests that are processed at the
+// same time on a single connection.
+//
+// Once the limit is reached, new requests wait until a request is done, or
+// until they are flushed or time out. Flushes, clunks and versions are not
+// counted, as they end blocked requests.
+func MaxConnRequests(n int) Option {
+	return func(s *Server) {
+		s.maxConnReqs = n
+	}
+}
+
+// MaxRequests limits the number of requests that are processed at the same
+// time on all connections of a Server.
+//
+// Once the limit is reached, new requests wait until a request is done, or
+// until they are flushed or time out. Flushes, clunks and versions are not
+// counted, as they end blocked requests.
+func MaxRequests(n int) Option {
+	return func(s *Server) {
+		s.reqSlots = nil
+		if n > 0 {
+			s.reqSlots = make(chan struct{}, n)
+		}
+	}
+}
+
+// RequestTimeout sets the time after which requests fail with ErrTimeout.
+//
+// The Node operation of a request that timed out is not interrupted, it keeps
+// counting towards the request limits until it returns.
+func RequestTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.reqTimeout = d
+	}
+}
+
+// Qids sets the QidAllocator used for files that don't set their qid path.
+//
+// By default, every path and Identifier gets an unique qid path.
+func Qids(a QidAllocator) Option {
+	return func(s *Server) {
+		s.qids = a
+	}
+}
+
 type StatModifierFn func(path []string, st *Stat, qidonly bool) error
 
 func StatModifier(sm StatModifierFn) Option {
-	return func(s *server) { s.statMods = append(s.statMods, sm) }
+	return func(s *Server) { s.statMods = append(s.statMods, sm) }
 }
 
 func DefaultOwner(user, group string) Option {
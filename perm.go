@@ -0,0 +1,141 @@
+package np
+
+import (
+	"context"
+	"fmt"
+)
+
+// GroupResolver resolves group membership for permission checks.
+type GroupResolver interface {
+	// IsMember returns true if user is a member of group.
+	IsMember(user, group string) bool
+}
+
+// noGroups is the default GroupResolver, users are not a member of any group.
+type noGroups struct{}
+
+func (noGroups) IsMember(user, group string) bool { return false }
+
+// Permission bits of the owner, group and other parts of a Mode.
+const (
+	permRead  Mode = 0o4
+	permWrite Mode = 0o2
+	permExec  Mode = 0o1
+)
+
+// chmodBits are the bits of a Mode that chmod(2) changes.
+const chmodBits = ModePerm | ModeSetuid | ModeSetgid
+
+// openPerm returns the permissions needed to open a file with mode.
+func openPerm(mode OpenMode) Mode {
+	var perm Mode
+	switch mode & 3 {
+	case ORead:
+		perm = permRead
+	case OWrite:
+		perm = permWrite
+	case ORdwr:
+		perm = permRead | permWrite
+	case OExec:
+		perm = permExec
+	}
+
+	if mode&OTrunc != 0 {
+		perm |= permWrite
+	}
+
+	return perm
+}
+
+// canRead returns true if a file opened with mode can be read.
+func canRead(mode OpenMode) bool {
+	return mode&3 != OWrite
+}
+
+// canWrite returns true if a file opened with mode can be written.
+func canWrite(mode OpenMode) bool {
+	return mode&3 == OWrite || mode&3 == ORdwr
+}
+
+// unchecked returns true if the file with the stat st is not checked: if
+// checks are turned off, or the file has neither permissions nor an owner,
+// like Nodes that were written before permissions were checked.
+func (s *server) unchecked(st *Stat) bool {
+	return s.noPerms || (st.Mode&ModePerm == 0 && st.Uid == "")
+}
+
+// isOwner returns true if user owns the file with the stat st. Files without
+// an owner are owned by no one, not by the anonymous user.
+func isOwner(st *Stat, user string) bool {
+	return st.Uid != "" && st.Uid == user
+}
+
+// hasPerm returns true if user has all permissions of perm on a file with
+// the stat st.
+func (s *server) hasPerm(st *Stat, user string, perm Mode) bool {
+	if s.unchecked(st) || st.Mode&perm == perm {
+		return true
+	}
+	if isOwner(st, user) && (st.Mode>>6)&perm == perm {
+		return true
+	}
+	if st.Gid != "" && (st.Mode>>3)&perm == perm && s.groups.IsMember(user, st.Gid) {
+		return true
+	}
+	return false
+}
+
+// checkPerm returns ErrPerm if user doesn't have perm on node, which is found
+// at path in the tree aname.
+func (s *server) checkPerm(ctx context.Context, node Node, aname string, path []string, user string, perm Mode) error {
+	if s.noPerms {
+		return nil
+	}
+
+	st, err := StatNode(ctx, node)
+	if err != nil {
+		return fmt.Errorf("stat: %w", err)
+	}
+
+	if err = s.fillstat(aname, node, &st, false, path...); err != nil {
+		return err
+	}
+
+	if !s.hasPerm(&st, user, perm) {
+		return ErrPerm
+	}
+	return nil
+}
+
+// checkWstat returns ErrPerm if the user of fd is not allowed to make the
+// changes sc to the file fd points to, which has the filled stat st.
+//
+// Renaming needs write permission on the parent directory and changing the
+// length needs write permission on the file, everything else can only be
+// changed by the owner.
+func (s *server) checkWstat(ctx context.Context, fd *fd, st *Stat, sc StatChanges) error {
+	if s.noPerms {
+		return nil
+	}
+
+	if sc.Has(StatName) && len(fd.curPath()) > 0 {
+		pfd := fd.walk("..")
+		parent, err := s.walkfd(ctx, pfd)
+		if err != nil {
+			return err
+		}
+		if err = s.checkPerm(ctx, parent, pfd.aname, pfd.curPath(), fd.uname, permWrite); err != nil {
+			return err
+		}
+	}
+
+	if sc.Has(StatLength) && !s.hasPerm(st, fd.uname, permWrite) {
+		return ErrPerm
+	}
+
+	if sc.Fields&^(StatName|StatLength) != 0 && !s.unchecked(st) && !isOwner(st, fd.uname) {
+		return ErrPerm
+	}
+
+	return nil
+}
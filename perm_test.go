@@ -0,0 +1,115 @@
+package np_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/noonien/np"
+	"github.com/noonien/np/client"
+	"github.com/noonien/np/nptest"
+	"github.com/stretchr/testify/require"
+)
+
+// permFile is a file with a fixed owner and mode.
+type permFile struct {
+	name string
+	uid  string
+	mode np.Mode
+}
+
+func (f *permFile) Stat() (np.Stat, error) {
+	return np.Stat{Name: f.name, Uid: f.uid, Mode: f.mode, Qid: np.Qid{Type: np.QTFile}}, nil
+}
+
+func (f *permFile) ReadAt(p []byte, off int64) (int, error) { return 0, io.EOF }
+
+// permDir is a directory of permFiles, which anyone can read.
+type permDir []*permFile
+
+func (d permDir) Stat() (np.Stat, error) {
+	return np.Stat{Name: "/", Mode: np.ModeDir | 0o555, Qid: np.Qid{Type: np.QTDir}}, nil
+}
+
+func (d permDir) Children() ([]np.Stat, error) {
+	sts := make([]np.Stat, 0, len(d))
+	for _, f := range d {
+		st, _ := f.Stat()
+		sts = append(sts, st)
+	}
+	return sts, nil
+}
+
+func (d permDir) Walk(name string) (np.Node, error) { //nolint:ireturn
+	for _, f := range d {
+		if f.name == name {
+			return f, nil
+		}
+	}
+	return nil, np.ErrNotFound
+}
+
+var permFiles = permDir{
+	{name: "unowned", mode: 0o600},
+	{name: "legacy", mode: 0},
+	{name: "glenda", uid: "glenda", mode: 0o600},
+	{name: "public", uid: "glenda", mode: 0o604},
+}
+
+func TestPerm(t *testing.T) {
+	t.Parallel()
+
+	c := nptest.ServePipe(t, permFiles)
+	ctx := context.Background()
+
+	glenda, err := c.Client.Attach(ctx, nil, "glenda", "")
+	require.Nil(t, err)
+
+	tests := []struct {
+		root *client.Fid
+		name string
+		ok   bool
+	}{
+		// files without an owner aren't owned by the anonymous user
+		{c.Root, "unowned", false},
+		{glenda, "unowned", false},
+
+		// files without permissions and owner aren't checked
+		{c.Root, "legacy", true},
+
+		{c.Root, "glenda", false},
+		{glenda, "glenda", true},
+		{c.Root, "public", true},
+	}
+
+	for _, tt := range tests {
+		f, err := tt.root.Walk(ctx, tt.name)
+		require.Nil(t, err)
+		err = f.Open(ctx, np.ORead)
+		if tt.ok {
+			require.Nil(t, err, tt.name)
+		} else {
+			require.ErrorIs(t, err, np.ErrPerm, tt.name)
+		}
+	}
+
+	// the owner can't be changed by users that don't own the file
+	f, err := c.Root.Walk(ctx, "unowned")
+	require.Nil(t, err)
+	st := client.DontTouch()
+	st.Mode = 0o666
+	require.ErrorIs(t, f.Wstat(ctx, st), np.ErrPerm)
+}
+
+func TestNoPermissions(t *testing.T) {
+	t.Parallel()
+
+	c := nptest.ServePipe(t, permFiles, np.NoPermissions())
+	ctx := context.Background()
+
+	for _, name := range []string{"unowned", "glenda"} {
+		f, err := c.Root.Walk(ctx, name)
+		require.Nil(t, err)
+		require.Nil(t, f.Open(ctx, np.ORead), name)
+	}
+}
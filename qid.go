@@ -0,0 +1,283 @@
+package np
+
+import (
+	"container/list"
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// QidAllocator allocates the qid paths of files that don't set one.
+type QidAllocator interface {
+	// QidPath returns the qid path of the file at path in the tree aname,
+	// the aname of the attach. id is the identity of the file's Node, if
+	// it's an Identifier, and nil otherwise.
+	//
+	// Files that have different trees, paths or identities must have
+	// different qid paths.
+	QidPath(aname string, path []string, id any) uint64
+}
+
+// qidTable is the default QidAllocator.
+//
+// Every path of every tree and every identity gets a new qid path the first
+// time it's seen. The
+// path an identity is found at refers to the identity's qid path, so that the
+// entries of a directory listing have the same qid path as the walked files,
+// once they were walked to. When an identity moves, its old path is dropped.
+//
+// Only the maxEntries most recently used entries are kept, a file whose entry
+// was evicted gets a new qid path the next time it's seen.
+type qidTable struct {
+	mu         sync.Mutex
+	last       uint64
+	maxEntries int
+
+	// paths are the entries by the path they're at, ids the entries of
+	// identities, lru has all entries, most recently used first
+	paths map[string]*qidEntry
+	ids   map[any]*qidEntry
+	lru   *list.List
+
+	// dropped is called with the qid paths of the entries that are
+	// dropped, if it's set
+	dropped func(qpath uint64)
+}
+
+// qidEntry is the qid path of a path or identity.
+type qidEntry struct {
+	qpath uint64
+	key   string
+	id    any
+	elem  *list.Element
+}
+
+// defaultMaxQids is the number of entries kept by the default qidTable.
+const defaultMaxQids = 1 << 16
+
+func newQidTable() *qidTable {
+	return &qidTable{
+		maxEntries: defaultMaxQids,
+		paths:      map[string]*qidEntry{},
+		ids:        map[any]*qidEntry{},
+		lru:        list.New(),
+	}
+}
+
+func (t *qidTable) QidPath(aname string, path []string, id any) uint64 {
+	key := treeKey(aname, path)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if id == nil {
+		if e, ok := t.paths[key]; ok {
+			t.lru.MoveToFront(e.elem)
+			return e.qpath
+		}
+		return t.add(key, nil).qpath
+	}
+
+	e, ok := t.ids[id]
+	if !ok {
+		return t.add(key, id).qpath
+	}
+
+	if e.key != key {
+		if t.paths[e.key] == e {
+			delete(t.paths, e.key)
+		}
+		t.setPath(key, e)
+	}
+	t.lru.MoveToFront(e.elem)
+	return e.qpath
+}
+
+// add adds a new entry for key and id, with a new qid path.
+func (t *qidTable) add(key string, id any) *qidEntry {
+	t.last++
+	e := &qidEntry{qpath: t.last, key: key, id: id}
+	e.elem = t.lru.PushFront(e)
+	if id != nil {
+		t.ids[id] = e
+	}
+	t.setPath(key, e)
+
+	for t.lru.Len() > t.maxEntries {
+		t.remove(t.lru.Back().Value.(*qidEntry)) //nolint:forcetypeassert
+	}
+	return e
+}
+
+// setPath makes key refer to e. The entry of a path that key referred to is
+// dropped, as the file at key is now e.
+func (t *qidTable) setPath(key string, e *qidEntry) {
+	if old, ok := t.paths[key]; ok && old != e && old.id == nil {
+		t.remove(old)
+	}
+	e.key = key
+	t.paths[key] = e
+}
+
+// remove drops e from the table.
+func (t *qidTable) remove(e *qidEntry) {
+	if t.paths[e.key] == e {
+		delete(t.paths, e.key)
+	}
+	if e.id != nil && t.ids[e.id] == e {
+		delete(t.ids, e.id)
+	}
+	t.lru.Remove(e.elem)
+	if t.dropped != nil {
+		t.dropped(e.qpath)
+	}
+}
+
+// forget drops the entries of the file at path in the tree aname and of the
+// files below it, after it was removed.
+func (t *qidTable) forget(aname string, path []string) {
+	if len(path) == 0 {
+		return
+	}
+	key := treeKey(aname, path)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// keys of paths below path start with its key, see pathKey
+	for k, e := range t.paths {
+		if strings.HasPrefix(k, key) {
+			t.remove(e)
+		}
+	}
+}
+
+// move moves the entries of the file at oldpath in the tree aname and of the
+// files below it to newpath, after it was renamed, so that they keep their
+// qid paths.
+func (t *qidTable) move(aname string, oldpath, newpath []string) {
+	oldkey, newkey := treeKey(aname, oldpath), treeKey(aname, newpath)
+	if oldkey == newkey {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var moved []*qidEntry
+	for k, e := range t.paths {
+		if strings.HasPrefix(k, oldkey) {
+			delete(t.paths, k)
+			moved = append(moved, e)
+		}
+	}
+
+	// entries already at newpath are replaced
+	for k, e := range t.paths {
+		if strings.HasPrefix(k, newkey) {
+			delete(t.paths, k)
+			if e.id == nil {
+				t.remove(e)
+			}
+		}
+	}
+
+	for _, e := range moved {
+		t.setPath(newkey+e.key[len(oldkey):], e)
+	}
+}
+
+// removed tells the default qid allocator that the file at path in the tree
+// aname was removed.
+func (s *server) removed(aname string, path []string) {
+	if t, ok := s.qids.(*qidTable); ok {
+		t.forget(aname, path)
+	}
+}
+
+// renamed moves the fids in the tree of fd that are in the file at oldpath to
+// newpath, and tells the default qid allocator about the move.
+func (s *server) renamed(fd *fd, oldpath, newpath []string) {
+	s.fids.move(fd.root, oldpath, newpath)
+	if t, ok := s.qids.(*qidTable); ok {
+		t.move(fd.aname, oldpath, newpath)
+	}
+}
+
+// treeKey returns an unique string for path in the tree aname. Keys of the
+// paths below path start with it.
+func treeKey(aname string, path []string) string {
+	return pathKey(append([]string{aname}, path...))
+}
+
+// pathKey returns an unique string for path, names are prefixed by their
+// length, as they can contain any character.
+func pathKey(path []string) string {
+	var b strings.Builder
+	for _, name := range path {
+		b.WriteString(strconv.Itoa(len(name)))
+		b.WriteByte(':')
+		b.WriteString(name)
+	}
+	return b.String()
+}
+
+// versionTable tracks the versions of qid paths.
+type versionTable struct {
+	mu sync.Mutex
+	m  map[uint64]uint32
+}
+
+func (t *versionTable) get(qpath uint64) uint32 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.m[qpath]
+}
+
+// drop forgets the version of qpath, once it's no longer used.
+func (t *versionTable) drop(qpath uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.m, qpath)
+}
+
+func (t *versionTable) bump(qpath uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.m == nil {
+		t.m = map[uint64]uint32{}
+	}
+	t.m[qpath]++
+}
+
+// Touch increases the qid version of the file at path in the tree aname, to
+// tell clients that it was changed outside of the server. aname is "" for the
+// root passed to NewServer.
+//
+// Writes, creates, removes and wstats that go through the server increase the
+// versions of the files they change. Versions are only used for files that
+// don't set Stat.Qid.Version.
+func (srv *Server) Touch(aname string, path ...string) {
+	srv.versions.bump(srv.qids.QidPath(aname, path, nil))
+}
+
+// TouchQid is like Touch, for a file with the qid path qpath.
+func (srv *Server) TouchQid(qpath uint64) {
+	srv.versions.bump(qpath)
+}
+
+// touch increases the version of node, which is found at path in the tree
+// aname.
+func (s *server) touch(ctx context.Context, node Node, aname string, path []string) {
+	st, err := StatNode(ctx, node)
+	if err != nil {
+		return
+	}
+
+	if err = s.fillstat(aname, node, &st, true, path...); err != nil {
+		return
+	}
+
+	s.versions.bump(st.Qid.Path)
+}
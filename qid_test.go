@@ -0,0 +1,117 @@
+package np
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQidTable(t *testing.T) {
+	t.Parallel()
+
+	tbl := newQidTable()
+	a, b := new(int), new(int)
+
+	// paths keep their qid path, different paths get different ones
+	p := tbl.QidPath("", []string{"x"}, nil)
+	require.Equal(t, p, tbl.QidPath("", []string{"x"}, nil))
+	require.NotEqual(t, p, tbl.QidPath("", []string{"y"}, nil))
+
+	// identities at the same path get different qid paths
+	qa := tbl.QidPath("", []string{"net", "0"}, a)
+	qb := tbl.QidPath("", []string{"net", "0"}, b)
+	require.NotEqual(t, qa, qb)
+	require.Equal(t, qa, tbl.QidPath("", []string{"net", "1"}, a))
+
+	// listings see the identity that was last at a path
+	require.Equal(t, qa, tbl.QidPath("", []string{"net", "1"}, nil))
+	require.Equal(t, qb, tbl.QidPath("", []string{"net", "0"}, nil))
+
+	// a new file at the old path of an identity is not the identity
+	c := new(int)
+	qc := tbl.QidPath("", []string{"f"}, c)
+	require.Equal(t, qc, tbl.QidPath("", []string{"g"}, c))
+	qf := tbl.QidPath("", []string{"f"}, nil)
+	require.NotEqual(t, qc, qf)
+	require.NotEqual(t, qc, tbl.QidPath("", []string{"f"}, new(int)))
+}
+
+func TestQidTableRename(t *testing.T) {
+	t.Parallel()
+
+	tbl := newQidTable()
+	d := tbl.QidPath("", []string{"d"}, nil)
+	f := tbl.QidPath("", []string{"d", "f"}, nil)
+
+	tbl.move("", []string{"d"}, []string{"e"})
+	require.Equal(t, d, tbl.QidPath("", []string{"e"}, nil))
+	require.Equal(t, f, tbl.QidPath("", []string{"e", "f"}, nil))
+	require.NotEqual(t, d, tbl.QidPath("", []string{"d"}, nil))
+	require.NotEqual(t, f, tbl.QidPath("", []string{"d", "f"}, nil))
+
+	tbl.forget("", []string{"e"})
+	require.NotEqual(t, d, tbl.QidPath("", []string{"e"}, nil))
+	require.NotEqual(t, f, tbl.QidPath("", []string{"e", "f"}, nil))
+}
+
+func TestQidTableTrees(t *testing.T) {
+	t.Parallel()
+
+	tbl := newQidTable()
+	a := tbl.QidPath("a", []string{"x"}, nil)
+	b := tbl.QidPath("b", []string{"x"}, nil)
+	require.NotEqual(t, a, b)
+
+	// removes and renames only change their own tree
+	tbl.forget("a", []string{"x"})
+	require.Equal(t, b, tbl.QidPath("b", []string{"x"}, nil))
+	tbl.move("a", []string{"x"}, []string{"y"})
+	require.Equal(t, b, tbl.QidPath("b", []string{"x"}, nil))
+	tbl.move("b", []string{"x"}, []string{"y"})
+	require.Equal(t, b, tbl.QidPath("b", []string{"y"}, nil))
+	require.NotEqual(t, b, tbl.QidPath("a", []string{"y"}, nil))
+}
+
+func TestQidTableEvict(t *testing.T) {
+	t.Parallel()
+
+	tbl := newQidTable()
+	tbl.maxEntries = 2
+
+	x := tbl.QidPath("", []string{"x"}, nil)
+	id := new(int)
+	y := tbl.QidPath("", []string{"y"}, id)
+	tbl.QidPath("", []string{"x"}, nil)
+	tbl.QidPath("", []string{"z"}, nil)
+
+	// y was used least recently
+	require.Len(t, tbl.paths, 2)
+	require.Empty(t, tbl.ids)
+	require.Equal(t, 2, tbl.lru.Len())
+	require.Equal(t, x, tbl.QidPath("", []string{"x"}, nil))
+
+	// evicted files get new qid paths, which are not reused
+	require.Greater(t, tbl.QidPath("", []string{"y"}, id), y)
+}
+
+func TestVersionsDropped(t *testing.T) {
+	t.Parallel()
+
+	srv := NewServer(nil)
+	tbl := srv.qids.(*qidTable) //nolint:forcetypeassert
+	tbl.maxEntries = 2
+
+	// versions of removed files are dropped
+	x := tbl.QidPath("", []string{"x"}, nil)
+	srv.versions.bump(x)
+	require.Equal(t, uint32(1), srv.versions.get(x))
+	tbl.forget("", []string{"x"})
+	require.Zero(t, srv.versions.get(x))
+
+	// as are the versions of evicted files
+	y := tbl.QidPath("", []string{"y"}, nil)
+	srv.versions.bump(y)
+	tbl.QidPath("", []string{"z"}, nil)
+	tbl.QidPath("", []string{"w"}, nil)
+	require.Empty(t, srv.versions.m)
+}
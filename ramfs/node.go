@@ -0,0 +1,232 @@
+package ramfs
+
+import (
+	"io"
+	"time"
+
+	"github.com/noonien/np"
+)
+
+// node is a file or directory, as seen by user.
+type node struct {
+	fsys *FS
+	e    *entry
+	user string
+}
+
+var (
+	_ np.Wstater = &node{}
+	_ np.Remover = &node{}
+)
+
+func (n *node) Stat() (np.Stat, error) {
+	n.fsys.mu.Lock()
+	defer n.fsys.mu.Unlock()
+	return n.e.st, nil
+}
+
+func (n *node) Remove() error {
+	n.fsys.mu.Lock()
+	defer n.fsys.mu.Unlock()
+	return n.fsys.remove(n.e, n.user)
+}
+
+// Wstat changes the stat of the file, renames fail if the new name exists.
+func (n *node) Wstat(sc np.StatChanges) error {
+	n.fsys.mu.Lock()
+	defer n.fsys.mu.Unlock()
+
+	e := n.e
+	if sc.Has(np.StatLength) && e.isDir() {
+		return np.ErrIsDir
+	}
+
+	// rename first, it's the only change that can fail
+	if sc.Has(np.StatName) {
+		if e.parent == nil {
+			return np.ErrNoWstat
+		}
+		if err := n.fsys.rename(e, e.parent, sc.Name, false, n.user); err != nil {
+			return err
+		}
+	}
+
+	if sc.Has(np.StatLength) {
+		e.truncate(sc.Length)
+	}
+	if sc.Has(np.StatMode) {
+		e.st.Mode = e.st.Mode&np.ModeDir | sc.Mode&^np.ModeDir
+		e.st.Qid.Type = qidType(e.st.Mode)
+	}
+	if sc.Has(np.StatUid) {
+		e.st.Uid = sc.Uid
+	}
+	if sc.Has(np.StatGid) {
+		e.st.Gid = sc.Gid
+	}
+
+	e.modified(n.user)
+	if sc.Has(np.StatMtime) {
+		e.st.Mtime = sc.Mtime
+	}
+	if sc.Has(np.StatAtime) {
+		e.st.Atime = sc.Atime
+	}
+
+	return nil
+}
+
+// file is a file of an FS.
+type file struct {
+	node
+}
+
+var (
+	_ np.Opener   = &file{}
+	_ io.ReaderAt = &file{}
+	_ io.WriterAt = &file{}
+)
+
+// Open truncates the file for OTrunc, and refuses to open exclusive files
+// more than once.
+func (f *file) Open(mode np.OpenMode) (any, uint32, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	e := f.e
+	if e.st.Mode&np.ModeExcl != 0 {
+		if e.opened {
+			return nil, 0, np.ErrInUse
+		}
+		e.opened = true
+	}
+
+	if mode&np.OTrunc != 0 && e.st.Mode&np.ModeAppend == 0 {
+		e.truncate(0)
+		e.modified(f.user)
+	}
+
+	if e.st.Mode&np.ModeExcl != 0 {
+		return &exclFile{file: f}, 0, nil
+	}
+	return f, 0, nil
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	n := f.e.readAt(p, off)
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt writes p at off, or at the end of append-only files. Writing past
+// the end leaves a hole that reads as zeros.
+func (f *file) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, np.ErrBadOffset
+	}
+
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	e := f.e
+	if e.st.Mode&np.ModeAppend != 0 {
+		off = int64(e.st.Length)
+	}
+
+	e.writeAt(p, off)
+	e.modified(f.user)
+	return len(p), nil
+}
+
+// exclFile is an opened exclusive file.
+type exclFile struct {
+	*file
+}
+
+func (f *exclFile) Close() error {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	f.e.opened = false
+	return nil
+}
+
+// dir is a directory of an FS.
+type dir struct {
+	node
+}
+
+var (
+	_ np.Dir     = &dir{}
+	_ np.Creator = &dir{}
+	_ np.Renamer = &dir{}
+)
+
+func (d *dir) Children() ([]np.Stat, error) {
+	d.fsys.mu.Lock()
+	defer d.fsys.mu.Unlock()
+
+	d.e.st.Atime = time.Now()
+	return d.e.childStats(), nil
+}
+
+func (d *dir) Walk(name string) (np.Node, error) { //nolint:ireturn
+	d.fsys.mu.Lock()
+	defer d.fsys.mu.Unlock()
+
+	c, ok := d.e.children[name]
+	if !ok {
+		return nil, np.ErrNotFound
+	}
+	return d.child(c), nil
+}
+
+// child returns the Node of the child entry c.
+func (d *dir) child(c *entry) np.Node { //nolint:ireturn
+	n := node{fsys: d.fsys, e: c, user: d.user}
+	if c.isDir() {
+		return &dir{n}
+	}
+	return &file{n}
+}
+
+// Create creates a file owned by the user, with the permissions of the
+// directory applied to perm, see open(5).
+func (d *dir) Create(name string, perm np.Mode, mode np.OpenMode) (np.Node, error) { //nolint:ireturn
+	d.fsys.mu.Lock()
+	defer d.fsys.mu.Unlock()
+
+	if perm&np.ModeDir != 0 {
+		perm &= ^np.ModePerm | d.e.st.Mode&0o777
+	} else {
+		perm &= ^np.ModePerm | d.e.st.Mode&0o666
+	}
+
+	c, err := d.fsys.create(d.e, name, perm, d.user)
+	if err != nil {
+		return nil, err
+	}
+	return d.child(c), nil
+}
+
+// Rename moves the child oldname to newdir, replacing the file at newname.
+func (d *dir) Rename(oldname string, newdir np.Node, newname string) error {
+	nd, ok := np.UnwrapValue[*dir](newdir)
+	if !ok || nd.fsys != d.fsys {
+		return np.ErrCrossDevice
+	}
+
+	d.fsys.mu.Lock()
+	defer d.fsys.mu.Unlock()
+
+	c, ok := d.e.children[oldname]
+	if !ok {
+		return np.ErrNotFound
+	}
+	return d.fsys.rename(c, nd.e, newname, true, d.user)
+}
@@ -0,0 +1,491 @@
+// Package ramfs implements a file system that is kept in memory.
+//
+// The tree can be served and used from Go at the same time, all changes are
+// made under a single lock.
+package ramfs
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/noonien/np"
+)
+
+// blockSize is the size of the blocks file data is stored in.
+const blockSize = 8192
+
+// FS is an in-memory file system.
+type FS struct {
+	mu    sync.Mutex
+	root  *entry
+	paths uint64
+}
+
+// New returns an empty FS.
+//
+// The root directory has the mode 0777 and no owner, see np.DefaultOwner.
+func New() *FS {
+	fsys := &FS{}
+	fsys.root = fsys.newEntry("/", np.ModeDir|0o777, "", "")
+	return fsys
+}
+
+// Root returns the root directory, which is used by the user "". Files made
+// from Go have no owner, so only their permissions for others apply to
+// clients, unless the server is made with np.NoPermissions.
+//
+// See Attach to serve the FS to several users.
+func (fsys *FS) Root() np.Node { //nolint:ireturn
+	return &dir{node{fsys: fsys, e: fsys.root}}
+}
+
+// Attach returns the root directory for uname, it can be used with
+// np.Attach. Files created and changed through it are owned and modified by
+// uname.
+func (fsys *FS) Attach(uname, aname string) (np.Node, error) { //nolint:ireturn
+	return &dir{node{fsys: fsys, e: fsys.root, user: uname}}, nil
+}
+
+// entry is a file or directory.
+type entry struct {
+	st     np.Stat
+	parent *entry
+
+	// children of a directory
+	children map[string]*entry
+
+	// blocks of a file, missing blocks are zeros
+	blocks map[int64][]byte
+
+	// opened is true if an exclusive file is opened
+	opened bool
+}
+
+func (fsys *FS) newEntry(name string, mode np.Mode, uid, gid string) *entry {
+	fsys.paths++
+	now := time.Now()
+
+	e := &entry{
+		st: np.Stat{
+			Qid:   np.Qid{Type: qidType(mode), Path: fsys.paths},
+			Mode:  mode,
+			Atime: now,
+			Mtime: now,
+			Name:  name,
+			Uid:   uid,
+			Gid:   gid,
+			Muid:  uid,
+		},
+	}
+
+	if mode&np.ModeDir != 0 {
+		e.children = make(map[string]*entry)
+	} else {
+		e.blocks = make(map[int64][]byte)
+	}
+	return e
+}
+
+// qidType returns the qid type of a file with mode.
+func qidType(mode np.Mode) np.QidType {
+	t := np.QTFile
+	if mode&np.ModeDir != 0 {
+		t |= np.QTDir
+	}
+	if mode&np.ModeAppend != 0 {
+		t |= np.QTAppend
+	}
+	if mode&np.ModeExcl != 0 {
+		t |= np.QTExcl
+	}
+	if mode&np.ModeTmp != 0 {
+		t |= np.QTTmp
+	}
+	return t
+}
+
+func (e *entry) isDir() bool {
+	return e.children != nil
+}
+
+// modified records a change of e by user.
+func (e *entry) modified(user string) {
+	e.st.Mtime = time.Now()
+	e.st.Atime = e.st.Mtime
+	e.st.Muid = user
+	e.st.Qid.Version++
+}
+
+// readAt reads the data of a file.
+func (e *entry) readAt(p []byte, off int64) int {
+	length := int64(e.st.Length)
+	if off >= length {
+		return 0
+	}
+	if int64(len(p)) > length-off {
+		p = p[:length-off]
+	}
+
+	for n := 0; n < len(p); {
+		o := off + int64(n)
+		b, bo := o/blockSize, o%blockSize
+
+		dst := p[n:]
+		if len(dst) > int(blockSize-bo) {
+			dst = dst[:blockSize-bo]
+		}
+
+		if blk, ok := e.blocks[b]; ok {
+			copy(dst, blk[bo:])
+		} else {
+			for i := range dst {
+				dst[i] = 0
+			}
+		}
+		n += len(dst)
+	}
+
+	e.st.Atime = time.Now()
+	return len(p)
+}
+
+// writeAt writes the data of a file, the file grows as needed.
+func (e *entry) writeAt(p []byte, off int64) {
+	for n := 0; n < len(p); {
+		o := off + int64(n)
+		b, bo := o/blockSize, o%blockSize
+
+		blk, ok := e.blocks[b]
+		if !ok {
+			blk = make([]byte, blockSize)
+			e.blocks[b] = blk
+		}
+		n += copy(blk[bo:], p[n:])
+	}
+
+	if end := uint64(off) + uint64(len(p)); end > e.st.Length {
+		e.st.Length = end
+	}
+}
+
+// truncate sets the length of a file, data past length is dropped.
+func (e *entry) truncate(length uint64) {
+	if length < e.st.Length {
+		last := int64(length) / blockSize
+		for b := range e.blocks {
+			if b > last {
+				delete(e.blocks, b)
+			}
+		}
+
+		// zero the rest of the last block, so growing the file again
+		// reads zeros
+		if blk, ok := e.blocks[last]; ok {
+			tail := blk[int64(length)%blockSize:]
+			for i := range tail {
+				tail[i] = 0
+			}
+		}
+	}
+
+	e.st.Length = length
+}
+
+// lookup returns the entry at the slash separated path p.
+func (fsys *FS) lookup(p string) (*entry, error) {
+	e := fsys.root
+	for _, name := range splitPath(p) {
+		if !e.isDir() {
+			return nil, np.ErrNotDir
+		}
+
+		c, ok := e.children[name]
+		if !ok {
+			return nil, np.ErrNotFound
+		}
+		e = c
+	}
+	return e, nil
+}
+
+// lookupParent returns the directory of the file at p, and the name of the
+// file.
+func (fsys *FS) lookupParent(p string) (*entry, string, error) {
+	names := splitPath(p)
+	if len(names) == 0 {
+		return nil, "", np.ErrInvalidArg
+	}
+
+	parent, err := fsys.lookup(strings.Join(names[:len(names)-1], "/"))
+	if err != nil {
+		return nil, "", err
+	}
+	if !parent.isDir() {
+		return nil, "", np.ErrNotDir
+	}
+
+	return parent, names[len(names)-1], nil
+}
+
+// splitPath returns the names of the slash separated path p.
+func splitPath(p string) []string {
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+// create creates the child name of dir, owned by user.
+func (fsys *FS) create(dir *entry, name string, mode np.Mode, user string) (*entry, error) {
+	if !dir.isDir() {
+		return nil, np.ErrNotDir
+	}
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return nil, np.ErrIllegalName
+	}
+	if _, ok := dir.children[name]; ok {
+		return nil, np.ErrExists
+	}
+
+	// new files get the group of their directory, see open(5)
+	e := fsys.newEntry(name, mode, user, dir.st.Gid)
+	e.parent = dir
+	dir.children[name] = e
+	dir.modified(user)
+
+	return e, nil
+}
+
+// remove removes e from its directory.
+func (fsys *FS) remove(e *entry, user string) error {
+	if e.parent == nil {
+		return np.ErrNoRemove
+	}
+	if e.isDir() && len(e.children) > 0 {
+		return np.ErrDirNotEmpty
+	}
+
+	delete(e.parent.children, e.st.Name)
+	e.parent.modified(user)
+	e.parent = nil
+	return nil
+}
+
+// rename moves e to the directory ndir with the name newname.
+//
+// An existing file at newname is replaced, unless it's a directory that is
+// not empty or replace is false.
+func (fsys *FS) rename(e, ndir *entry, newname string, replace bool, user string) error {
+	if e.parent == nil {
+		return np.ErrNoWstat
+	}
+	if newname == "" || newname == "." || newname == ".." || strings.Contains(newname, "/") {
+		return np.ErrIllegalName
+	}
+	if !ndir.isDir() {
+		return np.ErrNotDir
+	}
+
+	// directories can't be moved into themselves
+	for d := ndir; d != nil; d = d.parent {
+		if d == e {
+			return np.ErrInvalidArg
+		}
+	}
+
+	if old, ok := ndir.children[newname]; ok && old != e {
+		if !replace {
+			return np.ErrExists
+		}
+		if old.isDir() != e.isDir() {
+			if old.isDir() {
+				return np.ErrIsDir
+			}
+			return np.ErrNotDir
+		}
+		if err := fsys.remove(old, user); err != nil {
+			return err
+		}
+	}
+
+	odir := e.parent
+	delete(odir.children, e.st.Name)
+	odir.modified(user)
+
+	e.st.Name = newname
+	e.parent = ndir
+	ndir.children[newname] = e
+	ndir.modified(user)
+
+	return nil
+}
+
+// childStats returns the stats of the children of dir, sorted by name.
+func (dir *entry) childStats() []np.Stat {
+	sts := make([]np.Stat, 0, len(dir.children))
+	for _, c := range dir.children {
+		sts = append(sts, c.st)
+	}
+	sort.Slice(sts, func(i, j int) bool {
+		return sts[i].Name < sts[j].Name
+	})
+	return sts
+}
+
+// Mkdir creates the directory name.
+func (fsys *FS) Mkdir(name string, perm np.Mode) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	dir, base, err := fsys.lookupParent(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = fsys.create(dir, base, np.ModeDir|perm&np.ModePerm, "")
+	return err
+}
+
+// MkdirAll creates the directory name and the directories leading to it, if
+// they don't exist.
+func (fsys *FS) MkdirAll(name string, perm np.Mode) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	e := fsys.root
+	for _, n := range splitPath(name) {
+		if !e.isDir() {
+			return np.ErrNotDir
+		}
+
+		c, ok := e.children[n]
+		if !ok {
+			var err error
+			if c, err = fsys.create(e, n, np.ModeDir|perm&np.ModePerm, ""); err != nil {
+				return err
+			}
+		}
+		e = c
+	}
+
+	if !e.isDir() {
+		return np.ErrNotDir
+	}
+	return nil
+}
+
+// WriteFile writes data to the file name, which is created with perm if it
+// doesn't exist, and truncated otherwise.
+func (fsys *FS) WriteFile(name string, data []byte, perm np.Mode) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	dir, base, err := fsys.lookupParent(name)
+	if err != nil {
+		return err
+	}
+
+	e, ok := dir.children[base]
+	if !ok {
+		if e, err = fsys.create(dir, base, perm&^np.ModeDir, ""); err != nil {
+			return err
+		}
+	} else if e.isDir() {
+		return np.ErrIsDir
+	}
+
+	e.truncate(0)
+	e.writeAt(data, 0)
+	e.modified("")
+	return nil
+}
+
+// maxReadFile is the largest file ReadFile reads. Clients can set any length
+// with a wstat, the file doesn't need to fit in memory to be served.
+const maxReadFile = 1 << 30
+
+// ReadFile returns the contents of the file name. Files larger than 1GiB fail
+// with np.ErrTooBig.
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	e, err := fsys.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if e.isDir() {
+		return nil, np.ErrIsDir
+	}
+
+	if e.st.Length > maxReadFile {
+		return nil, np.ErrTooBig
+	}
+
+	data := make([]byte, e.st.Length)
+	e.readAt(data, 0)
+	return data, nil
+}
+
+// Remove removes the file or empty directory name.
+func (fsys *FS) Remove(name string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	e, err := fsys.lookup(name)
+	if err != nil {
+		return err
+	}
+	return fsys.remove(e, "")
+}
+
+// Rename moves the file oldname to newname, replacing the file at newname if
+// there is one.
+func (fsys *FS) Rename(oldname, newname string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	e, err := fsys.lookup(oldname)
+	if err != nil {
+		return err
+	}
+
+	ndir, base, err := fsys.lookupParent(newname)
+	if err != nil {
+		return err
+	}
+
+	return fsys.rename(e, ndir, base, true, "")
+}
+
+// Stat returns the stat of the file name.
+func (fsys *FS) Stat(name string) (np.Stat, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	e, err := fsys.lookup(name)
+	if err != nil {
+		return np.Stat{}, err
+	}
+	return e.st, nil
+}
+
+// ReadDir returns the stats of the files in the directory name, sorted by
+// name.
+func (fsys *FS) ReadDir(name string) ([]np.Stat, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	e, err := fsys.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.isDir() {
+		return nil, np.ErrNotDir
+	}
+	return e.childStats(), nil
+}
@@ -0,0 +1,167 @@
+package ramfs_test
+
+import (
+	"context"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/noonien/np"
+	"github.com/noonien/np/client"
+	"github.com/noonien/np/nptest"
+	"github.com/noonien/np/ramfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRamFS(t *testing.T) {
+	t.Parallel()
+
+	rfs := ramfs.New()
+	require.Nil(t, rfs.MkdirAll("a/b", 0o755))
+	require.Nil(t, rfs.WriteFile("a/b/c", []byte("hello"), 0o644))
+
+	// the files have no owner, the user "" doesn't own them
+	c := nptest.ServePipe(t, rfs.Root(), np.NoPermissions())
+	require.Nil(t, fstest.TestFS(c, "a/b/c"))
+
+	// files created by clients are seen from Go
+	require.Nil(t, c.Create("a/d", 0o644, []byte("world")))
+	data, err := rfs.ReadFile("a/d")
+	require.Nil(t, err)
+	require.Equal(t, "world", string(data))
+
+	// writes past the end leave holes
+	ctx := context.Background()
+	f, err := c.Root.Walk(ctx, "a", "d")
+	require.Nil(t, err)
+	require.Nil(t, f.Open(ctx, np.OWrite))
+	_, err = f.Write(ctx, []byte("!"), 10000)
+	require.Nil(t, err)
+	require.Nil(t, f.Clunk(ctx))
+
+	data, err = fs.ReadFile(c, "a/d")
+	require.Nil(t, err)
+	require.Len(t, data, 10001)
+	require.Equal(t, "world", string(data[:5]))
+	require.Equal(t, make([]byte, 10000-5), data[5:10000])
+
+	// truncate and rename through wstat
+	st := client.DontTouch()
+	st.Length = 2
+	st.Name = "e"
+	require.Nil(t, c.Wstat("a/d", st))
+	data, err = rfs.ReadFile("a/e")
+	require.Nil(t, err)
+	require.Equal(t, "wo", string(data))
+
+	rst, err := rfs.Stat("a/e")
+	require.Nil(t, err)
+	require.Equal(t, uint64(2), rst.Length)
+	require.NotZero(t, rst.Qid.Version)
+
+	require.Nil(t, c.Remove("a/e"))
+	_, err = rfs.Stat("a/e")
+	require.ErrorIs(t, err, np.ErrNotFound)
+}
+
+func TestRamFSUsers(t *testing.T) {
+	t.Parallel()
+
+	rfs := ramfs.New()
+	c := nptest.ServePipe(t, nil, np.Attach(rfs.Attach))
+
+	ctx := context.Background()
+	root, err := c.Client.Attach(ctx, nil, "glenda", "")
+	require.Nil(t, err)
+	require.Nil(t, root.Create(ctx, "f", 0o666, np.OWrite))
+	_, err = root.Write(ctx, []byte("data"), 0)
+	require.Nil(t, err)
+	require.Nil(t, root.Clunk(ctx))
+
+	st, err := rfs.Stat("f")
+	require.Nil(t, err)
+	require.Equal(t, "glenda", st.Uid)
+	require.Equal(t, "glenda", st.Muid)
+
+	// the user "" changes the file
+	st2 := client.DontTouch()
+	st2.Length = 1
+	require.Nil(t, c.Wstat("f", st2))
+
+	st, err = rfs.Stat("f")
+	require.Nil(t, err)
+	require.Equal(t, "glenda", st.Uid)
+	require.Equal(t, "", st.Muid)
+	require.Equal(t, uint64(1), st.Length)
+}
+
+func TestRamFSModes(t *testing.T) {
+	t.Parallel()
+
+	rfs := ramfs.New()
+	c := nptest.ServePipe(t, rfs.Root(), np.NoPermissions())
+	ctx := context.Background()
+
+	// exclusive files are opened once at a time
+	require.Nil(t, c.Create("excl", np.ModeExcl|0o666, nil))
+	f, err := c.Root.Walk(ctx, "excl")
+	require.Nil(t, err)
+	require.Nil(t, f.Open(ctx, np.ORead))
+	g, err := c.Root.Walk(ctx, "excl")
+	require.Nil(t, err)
+	require.ErrorIs(t, g.Open(ctx, np.ORead), np.ErrInUse)
+	require.Nil(t, f.Clunk(ctx))
+	require.Nil(t, g.Open(ctx, np.ORead))
+	require.Nil(t, g.Clunk(ctx))
+
+	// writes to append-only files go to the end
+	require.Nil(t, c.Create("log", np.ModeAppend|0o666, []byte("a")))
+	f, err = c.Root.Walk(ctx, "log")
+	require.Nil(t, err)
+	require.Nil(t, f.Open(ctx, np.OWrite))
+	_, err = f.Write(ctx, []byte("b"), 0)
+	require.Nil(t, err)
+	require.Nil(t, f.Clunk(ctx))
+	data, err := rfs.ReadFile("log")
+	require.Nil(t, err)
+	require.Equal(t, "ab", string(data))
+
+	// lengths set by clients aren't trusted by ReadFile
+	st := client.DontTouch()
+	st.Length = 1 << 62
+	require.Nil(t, c.Wstat("log", st))
+	_, err = rfs.ReadFile("log")
+	require.ErrorIs(t, err, np.ErrTooBig)
+}
+
+func TestRamFSRename(t *testing.T) {
+	t.Parallel()
+
+	rfs := ramfs.New()
+	require.Nil(t, rfs.MkdirAll("a", 0o777))
+	require.Nil(t, rfs.MkdirAll("b", 0o777))
+	require.Nil(t, rfs.WriteFile("a/f", []byte("f"), 0o666))
+
+	walk := func(name string) np.Node {
+		t.Helper()
+		d, ok := rfs.Root().(np.Dir)
+		require.True(t, ok)
+		n, err := d.Walk(name)
+		require.Nil(t, err)
+		return n
+	}
+
+	// files are moved across directories
+	a, ok := walk("a").(np.Renamer)
+	require.True(t, ok)
+	require.Nil(t, a.Rename("f", walk("b"), "g"))
+	_, err := rfs.Stat("a/f")
+	require.ErrorIs(t, err, np.ErrNotFound)
+	data, err := rfs.ReadFile("b/g")
+	require.Nil(t, err)
+	require.Equal(t, "f", string(data))
+
+	// but not to other file systems
+	require.Nil(t, rfs.WriteFile("a/h", nil, 0o666))
+	require.ErrorIs(t, a.Rename("h", ramfs.New().Root(), "h"), np.ErrCrossDevice)
+}
@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"go.rbn.im/neinp/fid"
 	"go.rbn.im/neinp/message"
 )
 
@@ -28,60 +31,325 @@ const (
 	DebugAll DebugFlags = DebugMessages | DebugErrors
 )
 
-type server struct {
+// dialect is a 9P protocol dialect.
+type dialect uint32
+
+const (
+	dialect9P dialect = iota
+	dialectU
+	dialectL
+)
+
+// Server serves a Node tree to 9P clients, over any number of connections.
+type Server struct {
+	// authPaths gives every auth fid an unique qid path, accessed
+	// atomically
+	authPaths uint64
+
 	root Node
 
 	msize    uint32
 	statMods []StatModifierFn
 	debug    DebugFlags
+	connErr  func(rwc io.ReadWriteCloser, err error)
+	groups   GroupResolver
+	noPerms  bool
+	authn    Authenticator
+	attachFn func(uname, aname string) (Node, error)
+	qids     QidAllocator
+	versions versionTable
+
+	// request limits, slots are acquired by sending to the semaphores
+	maxConnReqs int
+	reqSlots    chan struct{}
+	reqTimeout  time.Duration
+
+	mu         sync.Mutex
+	listeners  map[net.Listener]struct{}
+	conns      map[*server]struct{}
+	inShutdown bool
+}
+
+// ErrServerClosed is returned by Server's Serve methods after a Shutdown.
+var ErrServerClosed = errors.New("np: server closed")
+
+// server serves a single connection of a Server.
+type server struct {
+	*Server
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// negotiated protocol dialect and msize, accessed atomically
+	dialectv uint32
+	msizev   uint32
 
-	fids *fid.Map
+	fids *fidMap
+
+	// requests being processed on this connection, nil if not limited
+	connSlots chan struct{}
 
 	tagsMu sync.RWMutex
 	tags   map[uint16]context.CancelFunc
+
+	// in-flight requests, no new requests are handled once draining is set
+	inflightMu sync.Mutex
+	inflight   sync.WaitGroup
+	draining   bool
 }
 
 type response struct {
 	cancelled *bool
-	message.Message
+	fcall
+
+	// untracked responses are not counted as in-flight requests
+	untracked bool
+}
+
+// NewServer returns a Server that serves the root Node.
+// The root Node should be a Dir.
+func NewServer(root Node, opts ...Option) *Server {
+	const DefaultMsize = 0x2000
+	qids := newQidTable()
+	srv := &Server{
+		root:      root,
+		msize:     DefaultMsize,
+		groups:    noGroups{},
+		qids:      qids,
+		listeners: map[net.Listener]struct{}{},
+		conns:     map[*server]struct{}{},
+	}
+
+	// the versions of files the default allocator forgot are dropped too,
+	// they get new qid paths
+	qids.dropped = srv.versions.drop
+
+	for _, opt := range opts {
+		opt(srv)
+	}
+
+	return srv
 }
 
 // Serve starts a 9p server over the provided io.ReadWriter that serves the root Node.
 // The root Node should be a Dir.
 func Serve(ctx context.Context, rwc io.ReadWriteCloser, root Node, opts ...Option) error {
-	defer rwc.Close()
+	return NewServer(root, opts...).ServeConn(ctx, rwc)
+}
 
-	const DefaultMsize = 0x2000
-	s := &server{
-		root:  root,
-		msize: DefaultMsize,
-		fids:  fid.New(),
-		tags:  map[uint16]context.CancelFunc{},
+// ListenAndServe listens on the network address and serves connections from it.
+//
+// See net.Listen for the network and address formats.
+func (srv *Server) ListenAndServe(network, addr string) error {
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
 	}
+	return srv.Serve(l)
+}
 
-	for _, opt := range opts {
-		opt(s)
+// Serve accepts connections from l, serving each one in a new goroutine.
+//
+// Serve always returns an error, which is ErrServerClosed after Shutdown.
+// Errors that end a connection are reported to the ConnError Option.
+func (srv *Server) Serve(l net.Listener) error {
+	defer l.Close()
+
+	srv.mu.Lock()
+	if srv.inShutdown {
+		srv.mu.Unlock()
+		return ErrServerClosed
+	}
+	srv.listeners[l] = struct{}{}
+	srv.mu.Unlock()
+
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.listeners, l)
+		srv.mu.Unlock()
+	}()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if srv.shuttingDown() {
+				return ErrServerClosed
+			}
+			return fmt.Errorf("accept: %w", err)
+		}
+
+		go func() {
+			err := srv.ServeConn(context.Background(), c)
+			if srv.connErr != nil && !isClosedErr(err) {
+				srv.connErr(c, err)
+			}
+		}()
 	}
+}
+
+// ServeConn serves a single connection, until the connection is closed, ctx
+// is done or the Server is shut down.
+func (srv *Server) ServeConn(ctx context.Context, rwc io.ReadWriteCloser) error {
+	defer rwc.Close()
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	s := &server{
+		Server: srv,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		fids:   newFidMap(),
+		tags:   map[uint16]context.CancelFunc{},
+		msizev: srv.msize,
+	}
+	if srv.maxConnReqs > 0 {
+		s.connSlots = make(chan struct{}, srv.maxConnReqs)
+	}
+
+	if !srv.track(s) {
+		return ErrServerClosed
+	}
+	defer srv.untrack(s)
+	defer s.clunkAll()
+
 	in, rcvErr := s.rcv(ctx, rwc)
 	out := s.handle(ctx, in)
 	sendErr := s.send(ctx, out, rwc)
 
+	var err error
+	select {
+	case err = <-rcvErr:
+		err = fmt.Errorf("receive: %w", err)
+	case err = <-sendErr:
+		err = fmt.Errorf("send: %w", err)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	if s.isDraining() {
+		return ErrServerClosed
+	}
+	return err
+}
+
+// Shutdown gracefully shuts down the server.
+//
+// Listeners are closed first, then connections stop handling new requests,
+// which fail with ErrShutdown, and wait for in-flight requests to finish. Finally, every fid is clunked and the
+// connections are closed.
+//
+// If ctx is done before all connections finish, they are canceled without
+// waiting and an error wrapping ctx's error is returned. Their fids are
+// clunked once the requests still running return.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	srv.mu.Lock()
+	srv.inShutdown = true
+	for l := range srv.listeners {
+		l.Close()
+	}
+	conns := make([]*server, 0, len(srv.conns))
+	for s := range srv.conns {
+		conns = append(conns, s)
+	}
+	srv.mu.Unlock()
+
+	var wg sync.WaitGroup
+	var aborted int32
+	for _, s := range conns {
+		wg.Add(1)
+		go func(s *server) {
+			defer wg.Done()
+			if !s.shutdown(ctx) {
+				atomic.AddInt32(&aborted, 1)
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&aborted); n > 0 {
+		return fmt.Errorf("shutdown: %d connections closed with requests in flight: %w", n, ctx.Err())
+	}
+	return nil
+}
+
+func (srv *Server) shuttingDown() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.inShutdown
+}
+
+func (srv *Server) track(s *server) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.inShutdown {
+		return false
+	}
+	srv.conns[s] = struct{}{}
+	return true
+}
+
+func (srv *Server) untrack(s *server) {
+	srv.mu.Lock()
+	delete(srv.conns, s)
+	srv.mu.Unlock()
+	close(s.done)
+}
+
+// isClosedErr returns true if err is caused by a connection closing normally.
+func isClosedErr(err error) bool {
+	return err == nil ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, ErrServerClosed) ||
+		errors.Is(err, context.Canceled)
+}
+
+// shutdown stops handling new requests, waits for in-flight requests to finish
+// or for ctx to be done, then closes the connection. It returns false if ctx
+// was done first.
+func (s *server) shutdown(ctx context.Context) bool {
+	s.inflightMu.Lock()
+	s.draining = true
+	s.inflightMu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(drained)
+	}()
+
 	select {
-	case err := <-rcvErr:
-		return fmt.Errorf("receive: %w", err)
-	case err := <-sendErr:
-		return fmt.Errorf("send: %w", err)
+	case <-drained:
 	case <-ctx.Done():
-		return ctx.Err() //nolint:wrapcheck
+		// the fids of requests that are still running are clunked once
+		// they return
+		s.cancel()
+		return false
+	}
+
+	s.cancel()
+	<-s.done
+	return true
+}
+
+func (s *server) isDraining() bool {
+	s.inflightMu.Lock()
+	defer s.inflightMu.Unlock()
+	return s.draining
+}
+
+// clunkAll clunks all fids.
+func (s *server) clunkAll() {
+	for _, fd := range s.fids.Clear() {
+		if err := s.clunkfd(context.Background(), fd); err != nil && s.debug&DebugErrors != 0 {
+			log.Printf("clunk: %s", err)
+		}
 	}
 }
 
-func (s *server) rcv(ctx context.Context, r io.Reader) (<-chan message.Message, <-chan error) {
-	in := make(chan message.Message)
+func (s *server) rcv(ctx context.Context, r io.Reader) (<-chan fcall, <-chan error) {
+	in := make(chan fcall)
 	errch := make(chan error, 1)
 	done := ctx.Done()
 
@@ -89,10 +357,7 @@ func (s *server) rcv(ctx context.Context, r io.Reader) (<-chan message.Message,
 		defer close(in)
 
 		for {
-			lr := io.LimitReader(r, int64(s.msize))
-
-			var req message.Message
-			_, err := req.Decode(lr)
+			req, err := s.readMsg(r)
 			if err != nil {
 				errch <- fmt.Errorf("9p decode: %w", err)
 				return
@@ -113,6 +378,7 @@ func (s *server) rcv(ctx context.Context, r io.Reader) (<-chan message.Message,
 			select {
 			case <-done:
 				errch <- ctx.Err()
+				return
 			case in <- req:
 			}
 		}
@@ -121,23 +387,65 @@ func (s *server) rcv(ctx context.Context, r io.Reader) (<-chan message.Message,
 	return in, errch
 }
 
-func (s *server) handle(ctx context.Context, in <-chan message.Message) <-chan response {
+func (s *server) handle(ctx context.Context, in <-chan fcall) <-chan response {
 	out := make(chan response)
 	done := ctx.Done()
 
 	go func() {
-		defer close(out)
+		// out is closed once no request can send to it anymore
+		var running sync.WaitGroup
+		// processing tracks the requests until their processing is done,
+		// which can be after their response with a request timeout
+		var processing sync.WaitGroup
+		defer func() {
+			running.Wait()
+			close(out)
+		}()
 
 		for {
-			var req message.Message
+			var req fcall
+			var ok bool
 			select {
-			case req = <-in:
+			case req, ok = <-in:
+				if !ok {
+					return
+				}
 			case <-done:
 				return
 			}
 
+			// a version starts a new session, the requests of the previous
+			// one are aborted and must end before their fids are clunked
+			_, isVersion := req.Content.(*message.TVersion)
+			if isVersion {
+				s.cancelAll()
+				processing.Wait()
+			}
+
+			s.inflightMu.Lock()
+			draining := s.draining
+			if !draining {
+				s.inflight.Add(1)
+			}
+			s.inflightMu.Unlock()
+
+			if draining {
+				select {
+				case out <- s.refuse(ctx, req):
+				case <-done:
+					return
+				}
+				continue
+			}
+
 			var cancelled bool
-			rctx, cancel := context.WithCancel(ctx)
+			var rctx context.Context
+			var cancel context.CancelFunc
+			if s.reqTimeout > 0 {
+				rctx, cancel = context.WithTimeout(ctx, s.reqTimeout)
+			} else {
+				rctx, cancel = context.WithCancel(ctx)
+			}
 
 			s.tagsMu.Lock()
 			s.tags[req.Tag] = func() {
@@ -146,27 +454,38 @@ func (s *server) handle(ctx context.Context, in <-chan message.Message) <-chan r
 			}
 			s.tagsMu.Unlock()
 
-			// TODO: limit number of in-flight requests
-
+			running.Add(1)
+			processing.Add(1)
 			go func() {
-				c, err := s.process(req.Content)
-				if err != nil {
+				defer running.Done()
+
+				// slots are acquired here, so that the flushes and clunks
+				// that end blocked requests are still read
+				var c any
+				if release, err := s.acquire(rctx, req); err != nil {
+					processing.Done()
 					c = s.mapErr(err, req)
+				} else {
+					c = s.run(rctx, req, func() {
+						release()
+						processing.Done()
+					})
 				}
 
 				res := response{
 					cancelled: &cancelled,
-					Message: message.Message{
+					fcall: fcall{
 						Tag:     req.Tag,
 						Content: c,
 					},
 				}
 
+				// once sent, the response is marked as done by send, which
+				// also drops the responses of flushed requests
 				select {
 				case out <- res:
-				case <-rctx.Done():
 				case <-done:
-					return
+					s.inflight.Done()
 				}
 			}()
 		}
@@ -175,7 +494,105 @@ func (s *server) handle(ctx context.Context, in <-chan message.Message) <-chan r
 	return out
 }
 
-func (s *server) mapErr(err error, req message.Message) *message.RError {
+// refuse returns the response to req, which was received while the
+// connection is drained: requests fail with ErrShutdown, flushes are still
+// handled, for the requests that are drained.
+func (s *server) refuse(ctx context.Context, req fcall) response {
+	var c any
+	if _, ok := req.Content.(*message.TFlush); ok {
+		c = s.run(ctx, req, func() {})
+	} else {
+		c = s.mapErr(ErrShutdown, req)
+	}
+
+	s.tagsMu.Lock()
+	s.tags[req.Tag] = func() {}
+	s.tagsMu.Unlock()
+
+	return response{
+		cancelled: new(bool),
+		fcall:     fcall{Tag: req.Tag, Content: c},
+		untracked: true,
+	}
+}
+
+// acquire waits for a free request slot for req on the connection and on the
+// Server, and returns a function that releases them.
+//
+// Flushes, clunks and versions don't count towards the limits, as they are
+// needed to end requests that are blocked. It fails with the error of ctx if
+// ctx is done first.
+func (s *server) acquire(ctx context.Context, req fcall) (func(), error) {
+	switch req.Content.(type) {
+	case *message.TFlush, *message.TClunk, *message.TVersion:
+		return func() {}, nil
+	}
+
+	done := ctx.Done()
+	if s.connSlots != nil {
+		select {
+		case s.connSlots <- struct{}{}:
+		case <-done:
+			return nil, ctx.Err() //nolint:wrapcheck
+		}
+	}
+
+	if s.reqSlots != nil {
+		select {
+		case s.reqSlots <- struct{}{}:
+		case <-done:
+			if s.connSlots != nil {
+				<-s.connSlots
+			}
+			return nil, ctx.Err() //nolint:wrapcheck
+		}
+	}
+
+	return func() {
+		if s.reqSlots != nil {
+			<-s.reqSlots
+		}
+		if s.connSlots != nil {
+			<-s.connSlots
+		}
+	}, nil
+}
+
+// run processes req and returns the response, release is called once
+// processing is done.
+//
+// If ctx times out first, the response is ErrTimeout. The request keeps
+// running in the background and holds its slots until it's done.
+func (s *server) run(ctx context.Context, req fcall, release func()) any {
+	resc := make(chan any, 1)
+	process := func() {
+		defer release()
+
+		c, err := s.process(ctx, req)
+		if err != nil {
+			c = s.mapErr(err, req)
+		}
+		resc <- c
+	}
+
+	if s.reqTimeout <= 0 {
+		process()
+		return <-resc
+	}
+
+	go process()
+	select {
+	case c := <-resc:
+		return c
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return s.mapErr(ErrTimeout, req)
+		}
+		return <-resc
+	}
+}
+
+func (s *server) mapErr(err error, req fcall) any {
 	var reqfmt string
 	if s.debug&DebugErrors != 0 { //nolint:nestif
 		if s.debug&DebugReceived != 0 {
@@ -196,30 +613,46 @@ func (s *server) mapErr(err error, req message.Message) *message.RError {
 	}
 
 	var ne Error
-	if ok := errors.As(err, &ne); ok {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		ne = ErrTimeout
+	case errors.Is(err, context.Canceled):
+		ne = ErrInterrupted
+	}
+
+	if ok := ne.err != "" || errors.As(err, &ne); ok {
 		if s.debug&DebugKnownErrors != 0 {
 			log.Printf("error: %s (req %s)", ne.err, reqfmt)
 		}
-		return &message.RError{Ename: ne.err}
+	} else {
+		if s.debug&DebugUnknownErrors != 0 {
+			log.Printf("unknown error: %s (req %s)", err.Error(), reqfmt)
+		}
+
+		// map some errors?
+		ne = ErrIO
 	}
 
-	if s.debug&DebugUnknownErrors != 0 {
-		log.Printf("unknown error: %s (req %s)", err.Error(), reqfmt)
+	switch s.dialect() {
+	case dialectU:
+		return &rerroru{Ename: ne.err, Errno: ne.errno}
+	case dialectL:
+		return &rlerror{Ecode: ne.errno}
+	case dialect9P:
 	}
+	return &message.RError{Ename: ne.err}
+}
 
-	// map some errors?
-	return &message.RError{Ename: ErrIO.err}
+func (s *server) dialect() dialect {
+	return dialect(atomic.LoadUint32(&s.dialectv))
 }
 
 var ErrUnexpectedMessageType = errors.New("unexpected message type")
 
-func (s *server) process(c message.Content) (message.Content, error) { //nolint:ireturn
-	switch c := c.(type) {
+func (s *server) process(ctx context.Context, req fcall) (any, error) { //nolint:cyclop
+	switch c := req.Content.(type) {
 	case *message.TVersion:
-		return &message.RVersion{
-			Msize:   s.msize,
-			Version: "9P2000",
-		}, nil
+		return s.version(*c), nil
 
 	case *message.TFlush:
 		s.tagsMu.Lock()
@@ -238,34 +671,127 @@ func (s *server) process(c message.Content) (message.Content, error) { //nolint:
 	case *message.TAuth:
 		return s.auth(*c)
 	case *message.TAttach:
-		return s.attach(*c)
+		return s.attach(ctx, *c)
 	case *message.TWalk:
-		return s.walk(*c)
+		return s.walk(ctx, *c)
 	case *message.TOpen:
-		return s.open(*c)
+		return s.open(ctx, *c)
 	case *message.TCreate:
-		return s.create(*c)
+		return s.create(ctx, *c, "")
 	case *message.TRead:
-		return s.read(*c)
+		return s.read(ctx, *c)
 	case *message.TWrite:
-		return s.write(*c)
+		return s.write(ctx, *c)
 	case *message.TClunk:
-		if err := s.clunk(*c); err != nil {
+		if err := s.clunk(ctx, *c); err != nil {
 			return nil, err
 		}
 		return &message.RClunk{}, nil
 	case *message.TRemove:
-		return s.remove(*c)
+		return s.remove(ctx, *c)
 	case *message.TStat:
-		return s.stat(*c)
+		return s.stat(ctx, *c)
 	case *message.TWstat:
-		if err := s.wstat(*c); err != nil {
+		if err := s.wstat(ctx, *c); err != nil {
 			return nil, err
 		}
 		return &message.RWstat{}, nil
+
+	case *tcreateu:
+		return s.create(ctx, message.TCreate{Fid: c.Fid, Name: c.Name, Perm: c.Perm, Mode: c.Mode}, c.Extension)
+	case *twstatu:
+		if err := s.wstat(ctx, message.TWstat{Fid: c.Fid, Stat: c.wstatStat()}); err != nil {
+			return nil, err
+		}
+		return &message.RWstat{}, nil
+	case *tauthu:
+		return s.auth(message.TAuth{Afid: c.Afid, Uname: unameOf(c.Uname, c.Nuname), Aname: c.Aname})
+	case *tattachu:
+		return s.attach(ctx, message.TAttach{Fid: c.Fid, Afid: c.Afid, Uname: unameOf(c.Uname, c.Nuname), Aname: c.Aname})
+
+	case *tstatfs:
+		return s.statfs(ctx, c)
+	case *tlopen:
+		return s.lopen(ctx, c)
+	case *tlcreate:
+		return s.lcreate(ctx, c)
+	case *tgetattr:
+		return s.getattr(ctx, c)
+	case *tsetattr:
+		return s.setattr(ctx, c)
+	case *treaddir:
+		return s.readdir(ctx, c)
+	case *tfsync:
+		return s.fsync(ctx, c)
+	case *tmkdir:
+		return s.mkdir(ctx, c)
+	case *trenameat:
+		return s.renameat(ctx, c)
+	case *tunlinkat:
+		return s.unlinkat(ctx, c)
+
+	case *unknownMsg:
+		return nil, ErrOpNoSupported
+	}
+
+	panic(fmt.Sprintf("unexpected message type %T", req.Content))
+}
+
+// cancelAll aborts all outstanding requests, their responses are not sent.
+func (s *server) cancelAll() {
+	s.tagsMu.Lock()
+	for t, cancel := range s.tags {
+		cancel()
+		delete(s.tags, t)
+	}
+	s.tagsMu.Unlock()
+}
+
+// version negotiates the protocol version and msize, see version(5).
+//
+// It starts a new session: all fids are clunked. The outstanding requests
+// were aborted by handle, which waits for them to end first.
+func (s *server) version(m message.TVersion) *message.RVersion {
+	s.clunkAll()
+
+	msize := s.Server.msize
+	if m.Msize < msize {
+		msize = m.Msize
 	}
+	atomic.StoreUint32(&s.msizev, msize)
+
+	// unknown suffixes are stripped, see version(5)
+	version, suffix, _ := strings.Cut(m.Version, ".")
+	d := dialect9P
+	switch {
+	case version != "9P2000":
+		version = "unknown"
+	case suffix == "L":
+		version = "9P2000.L"
+		d = dialectL
+	case suffix == "u":
+		version = "9P2000.u"
+		d = dialectU
+	}
+	atomic.StoreUint32(&s.dialectv, uint32(d))
+
+	return &message.RVersion{
+		Msize:   msize,
+		Version: version,
+	}
+}
+
+// msize returns the negotiated maximum message size.
+func (s *server) msize() uint32 {
+	return atomic.LoadUint32(&s.msizev)
+}
 
-	panic(fmt.Sprintf("unexpected message type %W", c))
+// maxCount returns the maximum count of data in a Rread.
+func (s *server) maxCount() uint32 {
+	if msize := s.msize(); msize > ioHeaderSize {
+		return msize - ioHeaderSize
+	}
+	return 0
 }
 
 func (s *server) send(ctx context.Context, out <-chan response, w io.Writer) <-chan error {
@@ -275,36 +801,22 @@ func (s *server) send(ctx context.Context, out <-chan response, w io.Writer) <-c
 	go func() {
 		for {
 			var res response
+			var ok bool
 			select {
-			case res = <-out:
+			case res, ok = <-out:
+				if !ok {
+					errch <- ctx.Err()
+					return
+				}
 			case <-done:
 				errch <- ctx.Err()
 				return
 			}
 
-			s.tagsMu.Lock()
-			cancel, ok := s.tags[res.Tag]
-			if !ok || *res.cancelled {
-				s.tagsMu.Unlock()
-				continue
-			}
-			cancel()
-			delete(s.tags, res.Tag)
-			s.tagsMu.Unlock()
-
-			if s.debug&DebugSent != 0 {
-				c := res.Content
-				if s.debug&DebugData == 0 {
-					if r, ok := c.(*message.RRead); ok {
-						nr := *r
-						nr.Data = nil
-						c = &nr
-					}
-				}
-				log.Printf("-> %x %#v", res.Tag, c)
+			err := s.sendResponse(w, res)
+			if !res.untracked {
+				s.inflight.Done()
 			}
-
-			_, err := res.Encode(w)
 			if err != nil {
 				errch <- fmt.Errorf("9p encode: %w", err)
 				return
@@ -314,3 +826,30 @@ func (s *server) send(ctx context.Context, out <-chan response, w io.Writer) <-c
 
 	return errch
 }
+
+// sendResponse writes res to w, unless its request was flushed.
+func (s *server) sendResponse(w io.Writer, res response) error {
+	s.tagsMu.Lock()
+	cancel, ok := s.tags[res.Tag]
+	if !ok || *res.cancelled {
+		s.tagsMu.Unlock()
+		return nil
+	}
+	cancel()
+	delete(s.tags, res.Tag)
+	s.tagsMu.Unlock()
+
+	if s.debug&DebugSent != 0 {
+		c := res.Content
+		if s.debug&DebugData == 0 {
+			if r, ok := c.(*message.RRead); ok {
+				nr := *r
+				nr.Data = nil
+				c = &nr
+			}
+		}
+		log.Printf("-> %x %#v", res.Tag, c)
+	}
+
+	return writeMsg(w, res.fcall)
+}
@@ -0,0 +1,367 @@
+package np_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/noonien/np"
+	"github.com/noonien/np/client"
+	"github.com/noonien/np/iofs"
+	"github.com/noonien/np/nptest"
+	"github.com/stretchr/testify/require"
+	"go.rbn.im/neinp/message"
+)
+
+// blockingFile returns a file whose opens block until release is closed or
+// they are canceled, and a channel that gets a value when an open starts.
+func blockingFile() (np.Node, chan<- struct{}, <-chan struct{}) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	ff := np.ReadFunc("slow", func(ctx context.Context) ([]byte, error) {
+		started <- struct{}{}
+		select {
+		case <-release:
+			return []byte("done"), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+	return ff, release, started
+}
+
+// dial serves srv over net.Pipe, and returns a client connected to it.
+func dial(t *testing.T, srv *np.Server) *client.Client {
+	t.Helper()
+
+	ctx := context.Background()
+	sc, cc := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = srv.ServeConn(ctx, sc)
+	}()
+
+	c, err := client.NewClient(ctx, cc)
+	require.Nil(t, err)
+	t.Cleanup(func() {
+		c.Close()
+		<-done
+	})
+	return c
+}
+
+// serveConn serves srv over net.Pipe, and returns the root of an attach.
+func serveConn(t *testing.T, srv *np.Server) *client.Fid {
+	t.Helper()
+
+	root, err := dial(t, srv).Attach(context.Background(), nil, "", "")
+	require.Nil(t, err)
+	return root
+}
+
+func TestShutdownDrains(t *testing.T) {
+	t.Parallel()
+
+	node, release, started := blockingFile()
+	srv := np.NewServer(node)
+	root := serveConn(t, srv)
+	ctx := context.Background()
+
+	f, err := root.Walk(ctx)
+	require.Nil(t, err)
+	opened := make(chan error, 1)
+	go func() { opened <- f.Open(ctx, np.ORead) }()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown(ctx) }()
+
+	// new requests fail while the open is drained
+	for {
+		_, err = root.Stat(ctx)
+		if err != nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	require.ErrorIs(t, err, np.ErrShutdown)
+
+	close(release)
+	require.Nil(t, <-opened)
+	require.Nil(t, <-shutdown)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	t.Parallel()
+
+	node, release, started := blockingFile()
+	defer close(release)
+	srv := np.NewServer(node)
+	root := serveConn(t, srv)
+	ctx := context.Background()
+
+	f, err := root.Walk(ctx)
+	require.Nil(t, err)
+	go func() { _ = f.Open(ctx, np.ORead) }()
+	<-started
+
+	sctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, srv.Shutdown(sctx), context.DeadlineExceeded)
+}
+
+func TestVersionAborts(t *testing.T) {
+	t.Parallel()
+
+	node := &slowStat{
+		Node:    np.ReadFunc("slow", nil),
+		started: make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sc, cc := net.Pipe()
+	defer cc.Close()
+	go func() { _ = np.Serve(ctx, sc, node) }()
+
+	call := func(tag uint16, req message.Content) message.Content {
+		t.Helper()
+		m := message.Message{Tag: tag, Content: req}
+		_, err := m.Encode(cc)
+		require.Nil(t, err)
+		return recv(t, cc, tag)
+	}
+
+	const noTag, noFid = 0xffff, 0xffffffff
+	version := &message.TVersion{Msize: 8192, Version: "9P2000"}
+	require.IsType(t, &message.RVersion{}, call(noTag, version))
+	attach := &message.TAttach{Fid: 0, Afid: noFid}
+	require.IsType(t, &message.RAttach{}, call(1, attach))
+	require.IsType(t, &message.RWalk{}, call(1, &message.TWalk{Fid: 0, Newfid: 1}))
+
+	// the stat is aborted by the version, and gets no response
+	atomic.StoreInt32(&node.blocking, 1)
+	m := message.Message{Tag: 2, Content: &message.TStat{Fid: 1}}
+	_, err := m.Encode(cc)
+	require.Nil(t, err)
+	<-node.started
+	require.IsType(t, &message.RVersion{}, call(noTag, version))
+	require.Equal(t, int32(1), atomic.LoadInt32(&node.ended))
+
+	// the fids of the aborted session were clunked
+	atomic.StoreInt32(&node.blocking, 0)
+	require.IsType(t, &message.RAttach{}, call(1, attach))
+}
+
+// slowStat is a node whose stats block once blocking is set, until they are
+// canceled, and end some time after that.
+type slowStat struct {
+	np.Node
+	blocking int32
+	started  chan struct{}
+	ended    int32
+}
+
+func (n *slowStat) StatContext(ctx context.Context) (np.Stat, error) {
+	if atomic.LoadInt32(&n.blocking) == 0 {
+		return np.StatNode(ctx, n.Node)
+	}
+
+	close(n.started)
+	<-ctx.Done()
+	time.Sleep(50 * time.Millisecond)
+	atomic.StoreInt32(&n.ended, 1)
+	return np.Stat{}, ctx.Err()
+}
+
+// recv reads the next message from r, which must have tag.
+func recv(t *testing.T, r net.Conn, tag uint16) message.Content {
+	t.Helper()
+
+	var m message.Message
+	_, err := m.Decode(r)
+	require.Nil(t, err)
+	require.Equal(t, tag, m.Tag)
+	return m.Content
+}
+
+func TestRootsQids(t *testing.T) {
+	t.Parallel()
+
+	// the trees don't set qid paths
+	fsys := fstest.MapFS{"x": &fstest.MapFile{Data: []byte("x")}}
+	srv := np.NewServer(nil, np.Roots(map[string]np.Node{"a": iofs.New(fsys), "b": iofs.New(fsys)}))
+	c := dial(t, srv)
+	ctx := context.Background()
+
+	// the same path in different trees is a different file
+	var qids []np.Qid
+	for _, aname := range []string{"a", "b"} {
+		root, err := c.Attach(ctx, nil, "", aname)
+		require.Nil(t, err)
+		f, err := root.Walk(ctx, "x")
+		require.Nil(t, err)
+		qids = append(qids, root.Qid(), f.Qid())
+	}
+	require.NotEqual(t, qids[0].Path, qids[2].Path)
+	require.NotEqual(t, qids[1].Path, qids[3].Path)
+}
+
+// blockedRead opens the stream s through root and starts a read that blocks
+// until a message is published. It returns the fid and the read's result.
+func blockedRead(ctx context.Context, t *testing.T, root *client.Fid) (*client.Fid, <-chan error) {
+	t.Helper()
+
+	f, err := root.Walk(context.Background())
+	require.Nil(t, err)
+	require.Nil(t, f.Open(context.Background(), np.ORead))
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := f.Read(ctx, make([]byte, 64), 0)
+		errc <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	return f, errc
+}
+
+// stalls returns true if a stat of f doesn't return within a short time.
+func stalls(t *testing.T, f *client.Fid) bool {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := f.Stat(ctx)
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+func TestMaxConnRequests(t *testing.T) {
+	t.Parallel()
+
+	s := np.NewStream("events", 4, np.DropOldest)
+	c := nptest.ServePipe(t, s, np.MaxConnRequests(1))
+
+	// a flush ends the blocked read
+	ctx, cancel := context.WithCancel(context.Background())
+	_, errc := blockedRead(ctx, t, c.Root)
+	require.True(t, stalls(t, c.Root))
+	cancel()
+	require.ErrorIs(t, <-errc, context.Canceled)
+	_, err := c.Root.Stat(context.Background())
+	require.Nil(t, err)
+
+	// so does a clunk of its fid
+	f, errc := blockedRead(context.Background(), t, c.Root)
+	require.True(t, stalls(t, c.Root))
+	require.Nil(t, f.Clunk(context.Background()))
+	require.ErrorIs(t, <-errc, np.ErrInterrupted)
+	_, err = c.Root.Stat(context.Background())
+	require.Nil(t, err)
+}
+
+func TestMaxRequests(t *testing.T) {
+	t.Parallel()
+
+	s := np.NewStream("events", 4, np.DropOldest)
+	srv := np.NewServer(s, np.MaxRequests(1))
+	a, b := serveConn(t, srv), serveConn(t, srv)
+
+	// the limit is shared by all connections
+	_, errc := blockedRead(context.Background(), t, a)
+	require.True(t, stalls(t, b))
+	s.Publish([]byte("hello"))
+	require.Nil(t, <-errc)
+	_, err := b.Stat(context.Background())
+	require.Nil(t, err)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	t.Parallel()
+
+	s := np.NewStream("events", 4, np.DropOldest)
+	c := nptest.ServePipe(t, s, np.RequestTimeout(20*time.Millisecond))
+
+	_, errc := blockedRead(context.Background(), t, c.Root)
+	require.ErrorIs(t, <-errc, np.ErrTimeout)
+
+	// the timed out read doesn't hold up other requests
+	_, err := c.Root.Stat(context.Background())
+	require.Nil(t, err)
+}
+
+func TestTouch(t *testing.T) {
+	t.Parallel()
+
+	// the file doesn't set its qid version
+	srv := np.NewServer(np.ReadFunc("f", nil))
+	root := serveConn(t, srv)
+	ctx := context.Background()
+
+	version := func() uint32 {
+		st, err := root.Stat(ctx)
+		require.Nil(t, err)
+		return st.Qid.Version
+	}
+
+	v := version()
+	srv.Touch("")
+	require.Equal(t, v+1, version())
+
+	srv.TouchQid(root.Qid().Path)
+	require.Equal(t, v+2, version())
+}
+
+func TestRoots(t *testing.T) {
+	t.Parallel()
+
+	srv := np.NewServer(np.ReadFunc("main", nil), np.Roots(map[string]np.Node{
+		"other": np.ReadFunc("other", nil),
+	}))
+	c := dial(t, srv)
+	ctx := context.Background()
+
+	// the roots of different trees are different files
+	qids := map[uint64]bool{}
+	for aname, name := range map[string]string{"": "main", "other": "other"} {
+		root, err := c.Attach(ctx, nil, "", aname)
+		require.Nil(t, err)
+		st, err := root.Stat(ctx)
+		require.Nil(t, err)
+		require.Equal(t, name, st.Name)
+		qids[st.Qid.Path] = true
+	}
+	require.Len(t, qids, 2)
+
+	_, err := c.Attach(ctx, nil, "", "unknown")
+	require.ErrorIs(t, err, np.ErrBadAttach)
+}
+
+func TestAttach(t *testing.T) {
+	t.Parallel()
+
+	// every user gets its own tree
+	srv := np.NewServer(nil, np.Attach(func(uname, aname string) (np.Node, error) {
+		if uname == "" {
+			return nil, np.ErrPerm
+		}
+		return np.ReadFunc(uname+"-"+aname, nil), nil
+	}))
+	c := dial(t, srv)
+	ctx := context.Background()
+
+	_, err := c.Attach(ctx, nil, "", "")
+	require.ErrorIs(t, err, np.ErrPerm)
+
+	for _, uname := range []string{"glenda", "bootes"} {
+		root, err := c.Attach(ctx, nil, uname, "tree")
+		require.Nil(t, err)
+		st, err := root.Stat(ctx)
+		require.Nil(t, err)
+		require.Equal(t, uname+"-tree", st.Name)
+	}
+}
@@ -1,13 +1,16 @@
 package np
 
 import (
-	"hash/fnv"
+	"time"
 
 	"go.rbn.im/neinp/qid"
 )
 
-// fillstat fills empty stat fields.
-func (s *server) fillstat(st *Stat, qidonly bool, path ...string) error {
+// fillstat fills empty stat fields of node, which is found at path in the
+// tree aname.
+//
+// node is nil for the entries of a directory listing.
+func (s *server) fillstat(aname string, node Node, st *Stat, qidonly bool, path ...string) error {
 	if len(s.statMods) > 0 {
 		for _, sm := range s.statMods {
 			err := sm(path, st, qidonly)
@@ -22,12 +25,69 @@ func (s *server) fillstat(st *Stat, qidonly bool, path ...string) error {
 	}
 
 	if st.Qid.Path == 0 {
-		h := fnv.New64a()
-		for _, p := range path {
-			h.Write([]byte(p))
+		var id any
+		if i, ok := UnwrapValue[Identifier](node); ok {
+			id = i.Identity()
 		}
-		st.Qid.Path = h.Sum64()
+		st.Qid.Path = s.qids.QidPath(aname, path, id)
+	}
+
+	if st.Qid.Version == 0 {
+		st.Qid.Version = s.versions.get(st.Qid.Path)
+	}
+
+	// unset times are sent as the epoch, not as the year 1
+	if st.Atime.IsZero() {
+		st.Atime = time.Unix(0, 0)
+	}
+	if st.Mtime.IsZero() {
+		st.Mtime = time.Unix(0, 0)
 	}
 
 	return nil
 }
+
+// wstatChanges decodes the fields of a Twstat stat that should be changed.
+//
+// Fields that should not be changed have "don't touch" values: empty strings
+// or the maximum value of integers, see stat(5). Type, dev and qid can't be
+// changed.
+func wstatChanges(st *Stat) (StatChanges, error) {
+	sc := StatChanges{Stat: *st}
+
+	if st.Typ != ^uint16(0) || st.Dev != ^uint32(0) {
+		return sc, ErrNoWstat
+	}
+	if st.Qid.Type != ^QidType(0) || st.Qid.Version != ^uint32(0) || st.Qid.Path != ^uint64(0) {
+		return sc, ErrNoWstat
+	}
+
+	if st.Name != "" {
+		sc.Fields |= StatName
+	}
+	if st.Length != ^uint64(0) {
+		sc.Fields |= StatLength
+	}
+	if st.Mode != ^Mode(0) {
+		sc.Fields |= StatMode
+	}
+	if !dontTouchTime(st.Mtime) {
+		sc.Fields |= StatMtime
+	}
+	if !dontTouchTime(st.Atime) {
+		sc.Fields |= StatAtime
+	}
+	if st.Uid != "" {
+		sc.Fields |= StatUid
+	}
+	if st.Gid != "" {
+		sc.Fields |= StatGid
+	}
+
+	return sc, nil
+}
+
+// dontTouchTime returns true if t was decoded from a "don't touch" time.
+func dontTouchTime(t time.Time) bool {
+	return uint32(t.Unix()) == ^uint32(0)
+}
@@ -0,0 +1,218 @@
+package np
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// Overflow sets what a Stream does when the queue of a reader is full.
+type Overflow int
+
+const (
+	// DropOldest drops the oldest message of the queue to make room for the
+	// new one.
+	DropOldest Overflow = iota
+
+	// DropNewest drops the new message.
+	DropNewest
+
+	// Disconnect drops the queue, the next read of the reader fails with
+	// ErrNoBufferSpace and the reader gets no more messages.
+	Disconnect
+)
+
+// Stream is a file of events, like the event files of Plan 9.
+//
+// Messages are published from Go, and read by the fids that have the file
+// opened: every fid gets the messages published after it was opened, in
+// order. A read returns a single message, the rest of the message is
+// returned by the next reads if it doesn't fit. Reads block until a message
+// is published, the read is flushed or the Stream is closed, offsets are
+// ignored.
+type Stream struct {
+	name     string
+	queueLen int
+	overflow Overflow
+
+	mu      sync.Mutex
+	readers map[*streamFD]struct{}
+	closed  bool
+}
+
+// NewStream returns a Stream that queues up to queueLen messages for every
+// reader, and handles full queues with overflow.
+func NewStream(name string, queueLen int, overflow Overflow) *Stream {
+	if queueLen <= 0 {
+		queueLen = 1
+	}
+
+	return &Stream{
+		name:     name,
+		queueLen: queueLen,
+		overflow: overflow,
+		readers:  make(map[*streamFD]struct{}),
+	}
+}
+
+// streamFD is a Stream opened by a fid.
+type streamFD struct {
+	s *Stream
+
+	// wake is signaled when the queue changes
+	wake chan struct{}
+
+	// queue is guarded by the Stream's mu, rest is the unread part of the
+	// last message that was read
+	queue      [][]byte
+	rest       []byte
+	overflowed bool
+}
+
+var (
+	_ Node            = &Stream{}
+	_ Opener          = &Stream{}
+	_ ContextReaderAt = &streamFD{}
+	_ io.Closer       = &streamFD{}
+)
+
+func (s *Stream) Stat() (Stat, error) {
+	return Stat{
+		Qid:  Qid{Type: QTFile},
+		Mode: 0o444,
+		Name: s.name,
+	}, nil
+}
+
+// Open adds a reader, which gets the messages published from now on.
+func (s *Stream) Open(mode OpenMode) (any, uint32, error) {
+	if mode&3 != ORead {
+		return nil, 0, ErrPerm
+	}
+
+	fd := &streamFD{
+		s:    s,
+		wake: make(chan struct{}, 1),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.readers[fd] = struct{}{}
+	}
+	return fd, 0, nil
+}
+
+// Publish queues msg for all readers. Empty messages are not published, as
+// reading them would end the stream.
+func (s *Stream) Publish(msg []byte) {
+	if len(msg) == 0 {
+		return
+	}
+	msg = append([]byte{}, msg...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for fd := range s.readers {
+		if len(fd.queue) >= s.queueLen {
+			switch s.overflow {
+			case DropOldest:
+				fd.queue = fd.queue[1:]
+			case DropNewest:
+				continue
+			case Disconnect:
+				fd.queue = nil
+				fd.overflowed = true
+				delete(s.readers, fd)
+				fd.signal()
+				continue
+			}
+		}
+
+		fd.queue = append(fd.queue, msg)
+		fd.signal()
+	}
+}
+
+// Close ends the Stream, reads return EOF once the queued messages are read.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for fd := range s.readers {
+		delete(s.readers, fd)
+		fd.signal()
+	}
+	return nil
+}
+
+// signal wakes the blocked read of fd, if any.
+func (fd *streamFD) signal() {
+	select {
+	case fd.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the next message, blocking until there is one. It returns nil
+// at the end of the stream.
+func (fd *streamFD) next(ctx context.Context) ([]byte, error) {
+	s := fd.s
+	for {
+		s.mu.Lock()
+		if len(fd.queue) > 0 {
+			msg := fd.queue[0]
+			fd.queue[0] = nil
+			fd.queue = fd.queue[1:]
+			s.mu.Unlock()
+			return msg, nil
+		}
+
+		_, reading := s.readers[fd]
+		overflowed := fd.overflowed
+		s.mu.Unlock()
+
+		if overflowed {
+			return nil, ErrNoBufferSpace
+		}
+		if !reading {
+			return nil, nil
+		}
+
+		select {
+		case <-fd.wake:
+		case <-ctx.Done():
+			return nil, ErrInterrupted
+		}
+	}
+}
+
+func (fd *streamFD) ReadAtContext(ctx context.Context, p []byte, off int64) (int, error) {
+	if len(fd.rest) == 0 {
+		msg, err := fd.next(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if msg == nil {
+			return 0, io.EOF
+		}
+		fd.rest = msg
+	}
+
+	n := copy(p, fd.rest)
+	fd.rest = fd.rest[n:]
+	return n, nil
+}
+
+// Close removes the reader.
+func (fd *streamFD) Close() error {
+	fd.s.mu.Lock()
+	defer fd.s.mu.Unlock()
+
+	delete(fd.s.readers, fd)
+	fd.queue = nil
+	return nil
+}
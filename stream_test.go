@@ -0,0 +1,128 @@
+package np_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/noonien/np"
+	"github.com/noonien/np/client"
+	"github.com/noonien/np/nptest"
+	"github.com/stretchr/testify/require"
+)
+
+type readResult struct {
+	data string
+	err  error
+}
+
+// readAsync reads f in the background.
+func readAsync(ctx context.Context, f *client.Fid) <-chan readResult {
+	ch := make(chan readResult, 1)
+	go func() {
+		buf := make([]byte, 64)
+		n, err := f.Read(ctx, buf, 0)
+		ch <- readResult{string(buf[:n]), err}
+	}()
+	return ch
+}
+
+// openStream serves s and returns a fid that has it opened.
+func openStream(t *testing.T, s *np.Stream) (*nptest.Conn, *client.Fid) {
+	t.Helper()
+
+	c := nptest.ServePipe(t, s)
+	f, err := c.Root.Walk(context.Background())
+	require.Nil(t, err)
+	require.Nil(t, f.Open(context.Background(), np.ORead))
+	return c, f
+}
+
+func TestStreamBlocks(t *testing.T) {
+	t.Parallel()
+
+	s := np.NewStream("events", 4, np.DropOldest)
+	c, f := openStream(t, s)
+	ctx := context.Background()
+
+	ch := readAsync(ctx, f)
+	select {
+	case r := <-ch:
+		t.Fatalf("read returned before publish: %v", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the fid can be used while the read blocks
+	_, err := f.Stat(ctx)
+	require.Nil(t, err)
+	_, err = c.Root.Stat(ctx)
+	require.Nil(t, err)
+
+	s.Publish([]byte("hello"))
+	r := <-ch
+	require.Nil(t, r.err)
+	require.Equal(t, "hello", r.data)
+}
+
+func TestStreamFlush(t *testing.T) {
+	t.Parallel()
+
+	s := np.NewStream("events", 4, np.DropOldest)
+	_, f := openStream(t, s)
+	ctx := context.Background()
+
+	first := readAsync(ctx, f)
+	time.Sleep(20 * time.Millisecond)
+
+	// a read waiting behind the blocked one can be flushed
+	cctx, cancel := context.WithCancel(ctx)
+	second := readAsync(cctx, f)
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case r := <-second:
+		require.Error(t, r.err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("flushed read didn't return")
+	}
+
+	// and so can the blocked one
+	cctx, cancel = context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	third := readAsync(cctx, f)
+
+	s.Publish([]byte("one"))
+	r := <-first
+	require.Nil(t, r.err)
+	require.Equal(t, "one", r.data)
+	r = <-third
+	require.Error(t, r.err)
+
+	// the stream is still read in order
+	s.Publish([]byte("two"))
+	r = <-readAsync(ctx, f)
+	require.Nil(t, r.err)
+	require.Equal(t, "two", r.data)
+}
+
+func TestStreamClunk(t *testing.T) {
+	t.Parallel()
+
+	s := np.NewStream("events", 4, np.DropOldest)
+	_, f := openStream(t, s)
+	ctx := context.Background()
+
+	ch := readAsync(ctx, f)
+	time.Sleep(20 * time.Millisecond)
+
+	// clunking the fid interrupts the blocked read
+	done := make(chan error, 1)
+	go func() { done <- f.Clunk(ctx) }()
+	select {
+	case err := <-done:
+		require.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("clunk blocked behind read")
+	}
+	require.ErrorIs(t, (<-ch).err, np.ErrInterrupted)
+}
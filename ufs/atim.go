@@ -0,0 +1,9 @@
+//go:build dragonfly || linux || openbsd || solaris
+
+package ufs
+
+import "syscall"
+
+func statAtime(sys *syscall.Stat_t) syscall.Timespec {
+	return sys.Atim
+}
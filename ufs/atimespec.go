@@ -0,0 +1,9 @@
+//go:build darwin || freebsd || netbsd
+
+package ufs
+
+import "syscall"
+
+func statAtime(sys *syscall.Stat_t) syscall.Timespec {
+	return sys.Atimespec
+}
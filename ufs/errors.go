@@ -0,0 +1,57 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package ufs
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/noonien/np"
+)
+
+// errnos are the Errors sent for host errors.
+var errnos = map[syscall.Errno]np.Error{
+	syscall.EPERM:        np.ErrPerm,
+	syscall.EACCES:       np.ErrPerm,
+	syscall.ENOENT:       np.ErrNotFound,
+	syscall.EEXIST:       np.ErrExists,
+	syscall.ENOTDIR:      np.ErrNotDir,
+	syscall.EISDIR:       np.ErrIsDir,
+	syscall.ENOTEMPTY:    np.ErrDirNotEmpty,
+	syscall.EXDEV:        np.ErrCrossDevice,
+	syscall.EINVAL:       np.ErrInvalidArg,
+	syscall.EIO:          np.ErrIO,
+	syscall.EINTR:        np.ErrInterrupted,
+	syscall.EAGAIN:       np.ErrTempUnavailable,
+	syscall.EBADF:        np.ErrBadFD,
+	syscall.EBUSY:        np.ErrBusy,
+	syscall.ETXTBSY:      np.ErrInUse,
+	syscall.EFBIG:        np.ErrTooBig,
+	syscall.ENOSPC:       np.ErrNoSpace,
+	syscall.EDQUOT:       np.ErrQuota,
+	syscall.EROFS:        np.ErrReadOnlyFS,
+	syscall.ENAMETOOLONG: np.ErrIllegalName,
+	syscall.ELOOP:        np.ErrTooManyLevels,
+	syscall.EMLINK:       np.ErrTooManyLinks,
+	syscall.EMFILE:       np.ErrTooManyFiles,
+	syscall.ENFILE:       np.ErrTooManyOpenFiles,
+	syscall.ENOMEM:       np.ErrNoMem,
+	syscall.ENOSYS:       np.ErrNotImplemented,
+	syscall.EOPNOTSUPP:   np.ErrOpNoSupported,
+	syscall.ESPIPE:       np.ErrIllegalSeek,
+	syscall.ENXIO:        np.ErrNoDeviceOrAddr,
+	syscall.ENODEV:       np.ErrNoDevice,
+}
+
+// mapErr translates the syscall.Errno of err to an Error.
+//
+// Errors without a known Errno are returned as is.
+func mapErr(err error) error {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		if ne, ok := errnos[errno]; ok {
+			return ne
+		}
+	}
+	return err
+}
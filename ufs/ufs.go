@@ -0,0 +1,504 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+// Package ufs serves a directory of the host.
+//
+// Files can't be reached from outside the root directory, walking ".." stops
+// at the root and symlinks that point outside of it can't be followed. Only
+// the server is guarded against, not other processes that replace
+// directories with symlinks while they are used.
+package ufs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/noonien/np"
+	"github.com/noonien/np/iofs"
+)
+
+// ufs is a served host directory.
+type ufs struct {
+	// root is the absolute path of the root, with symlinks resolved
+	root string
+}
+
+// New returns the host directory root as a Node.
+func New(root string) (np.Node, error) { //nolint:ireturn
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("abs: %w", err)
+	}
+
+	if abs, err = filepath.EvalSymlinks(abs); err != nil {
+		return nil, fmt.Errorf("eval symlinks: %w", err)
+	}
+
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s: %w", root, np.ErrNotDir)
+	}
+
+	u := &ufs{root: abs}
+	return &dir{node{u: u}}, nil
+}
+
+// resolve returns the host path of the file at the slash separated path rel,
+// with symlinks resolved.
+//
+// ErrPerm is returned if the file is outside the root.
+func (u *ufs) resolve(rel string) (string, error) {
+	p, err := filepath.EvalSymlinks(filepath.Join(u.root, filepath.FromSlash(rel)))
+	if err != nil {
+		return "", mapErr(err)
+	}
+
+	if p != u.root && !strings.HasPrefix(p, u.root+string(filepath.Separator)) {
+		return "", np.ErrPerm
+	}
+	return p, nil
+}
+
+// resolveLink returns the host path of the file at rel, without resolving
+// the file itself if it's a symlink.
+func (u *ufs) resolveLink(rel string) (string, error) {
+	if rel == "" {
+		return u.root, nil
+	}
+
+	dir, name := splitPath(rel)
+	p, err := u.resolve(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(p, name), nil
+}
+
+// newNode returns the Node of the file at rel.
+func (u *ufs) newNode(rel string) (np.Node, error) { //nolint:ireturn
+	p, err := u.resolve(rel)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, mapErr(err)
+	}
+
+	n := node{u: u, rel: rel}
+	if fi.IsDir() {
+		return &dir{n}, nil
+	}
+	return &file{n}, nil
+}
+
+// node is the common part of files and directories.
+type node struct {
+	u *ufs
+
+	// rel is the slash separated path from the root, "" for the root
+	rel string
+}
+
+var (
+	_ np.Remover = &node{}
+	_ np.Wstater = &node{}
+	_ np.Syncer  = &node{}
+)
+
+func (n *node) Stat() (np.Stat, error) {
+	p, err := n.u.resolve(n.rel)
+	if err != nil {
+		return np.Stat{}, err
+	}
+
+	fi, err := os.Stat(p)
+	if err != nil {
+		return np.Stat{}, mapErr(err)
+	}
+
+	st := hostStat(fi)
+	if n.rel == "" {
+		st.Name = "/"
+	} else {
+		// the name of the symlink, not of its target
+		_, st.Name = splitPath(n.rel)
+	}
+	return st, nil
+}
+
+func (n *node) Remove() error {
+	if n.rel == "" {
+		return np.ErrNoRemove
+	}
+
+	p, err := n.u.resolveLink(n.rel)
+	if err != nil {
+		return err
+	}
+	return mapErr(os.Remove(p))
+}
+
+// Wstat changes the name, length, mode and times of the file. The name is
+// changed first, so that a name that is taken fails the Wstat before the
+// rest is changed.
+//
+// The owner can't be changed. If only one of the times is changed, the other
+// keeps its value.
+func (n *node) Wstat(sc np.StatChanges) error {
+	if sc.Fields&(np.StatUid|np.StatGid) != 0 {
+		return np.ErrNoWstat
+	}
+
+	rel := n.rel
+	if sc.Has(np.StatName) {
+		var err error
+		if rel, err = n.rename(sc.Name); err != nil {
+			return err
+		}
+	}
+
+	p, err := n.u.resolve(rel)
+	if err != nil {
+		return err
+	}
+
+	if sc.Has(np.StatLength) {
+		if err = os.Truncate(p, int64(sc.Length)); err != nil {
+			return mapErr(err)
+		}
+	}
+
+	if sc.Has(np.StatMode) {
+		if err = os.Chmod(p, np.FileMode(sc.Mode&np.ModePerm)); err != nil {
+			return mapErr(err)
+		}
+	}
+
+	if sc.Fields&(np.StatAtime|np.StatMtime) != 0 {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return mapErr(err)
+		}
+
+		atime, mtime := hostStat(fi).Atime, fi.ModTime()
+		if sc.Has(np.StatAtime) {
+			atime = sc.Atime
+		}
+		if sc.Has(np.StatMtime) {
+			mtime = sc.Mtime
+		}
+		if err = os.Chtimes(p, atime, mtime); err != nil {
+			return mapErr(err)
+		}
+	}
+
+	return nil
+}
+
+// rename renames the file within its directory, and returns its new rel
+// path. Files aren't replaced.
+func (n *node) rename(name string) (string, error) {
+	if n.rel == "" {
+		return "", np.ErrNoWstat
+	}
+	if !validName(name) {
+		return "", np.ErrIllegalName
+	}
+
+	old, err := n.u.resolveLink(n.rel)
+	if err != nil {
+		return "", err
+	}
+
+	p := filepath.Join(filepath.Dir(old), name)
+	if _, err = os.Lstat(p); err == nil {
+		return "", np.ErrExists
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", mapErr(err)
+	}
+
+	if err = os.Rename(old, p); err != nil {
+		return "", mapErr(err)
+	}
+
+	parent, _ := splitPath(n.rel)
+	return joinPath(parent, name), nil
+}
+
+// Sync commits the file to disk.
+func (n *node) Sync() error {
+	p, err := n.u.resolve(n.rel)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return mapErr(err)
+	}
+	defer f.Close()
+
+	return mapErr(f.Sync())
+}
+
+// file is a host file.
+type file struct {
+	node
+}
+
+var _ np.Opener = &file{}
+
+// Open opens the host file, the returned *os.File is used for I/O.
+func (f *file) Open(mode np.OpenMode) (any, uint32, error) {
+	p, err := f.u.resolve(f.rel)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	fl, err := os.OpenFile(p, openFlag(mode), 0)
+	if err != nil {
+		return nil, 0, mapErr(err)
+	}
+	return fl, 0, nil
+}
+
+// created is a file that was just created, its first Open returns the file
+// opened by Create, which might not be allowed to be opened again.
+type created struct {
+	*file
+	f *os.File
+}
+
+func (c *created) Open(mode np.OpenMode) (any, uint32, error) {
+	if f := c.f; f != nil {
+		c.f = nil
+		return f, 0, nil
+	}
+	return c.file.Open(mode)
+}
+
+// dir is a host directory.
+type dir struct {
+	node
+}
+
+var (
+	_ np.Dir       = &dir{}
+	_ np.DirLister = &dir{}
+	_ np.Creator   = &dir{}
+	_ np.Renamer   = &dir{}
+)
+
+func (d *dir) Children() ([]np.Stat, error) {
+	it, err := d.List(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer it.(io.Closer).Close() //nolint:forcetypeassert
+
+	var sts []np.Stat
+	for {
+		cs, err := it.Next(context.Background(), 64)
+		sts = append(sts, cs...)
+		if errors.Is(err, io.EOF) {
+			return sts, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (d *dir) Walk(name string) (np.Node, error) { //nolint:ireturn
+	if !validName(name) {
+		return nil, np.ErrNotFound
+	}
+	return d.u.newNode(d.child(name))
+}
+
+// child returns the rel path of the child name.
+func (d *dir) child(name string) string {
+	return joinPath(d.rel, name)
+}
+
+func (d *dir) List(ctx context.Context) (np.DirIterator, error) { //nolint:ireturn
+	p, err := d.u.resolve(d.rel)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, mapErr(err)
+	}
+	return &dirIterator{d: d, f: f}, nil
+}
+
+func (d *dir) Create(name string, perm np.Mode, mode np.OpenMode) (np.Node, error) { //nolint:ireturn
+	if !validName(name) {
+		return nil, np.ErrIllegalName
+	}
+
+	p, err := d.u.resolve(d.rel)
+	if err != nil {
+		return nil, err
+	}
+	p = filepath.Join(p, name)
+	fperm := np.FileMode(perm & np.ModePerm)
+
+	if perm&np.ModeDir != 0 {
+		if err = os.Mkdir(p, fperm); err != nil {
+			return nil, mapErr(err)
+		}
+		return &dir{node{u: d.u, rel: d.child(name)}}, nil
+	}
+
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|openFlag(mode), fperm)
+	if err != nil {
+		return nil, mapErr(err)
+	}
+
+	return &created{
+		file: &file{node{u: d.u, rel: d.child(name)}},
+		f:    f,
+	}, nil
+}
+
+// Rename moves the child oldname to newdir, which must be a directory of the
+// same tree.
+func (d *dir) Rename(oldname string, newdir np.Node, newname string) error {
+	nd, ok := np.UnwrapValue[*dir](newdir)
+	if !ok || nd.u != d.u {
+		return np.ErrCrossDevice
+	}
+	if !validName(oldname) || !validName(newname) {
+		return np.ErrIllegalName
+	}
+
+	old, err := d.u.resolveLink(d.child(oldname))
+	if err != nil {
+		return err
+	}
+
+	p, err := d.u.resolve(nd.rel)
+	if err != nil {
+		return err
+	}
+
+	return mapErr(os.Rename(old, filepath.Join(p, newname)))
+}
+
+// dirIterator lists a host directory.
+type dirIterator struct {
+	d *dir
+	f *os.File
+}
+
+func (it *dirIterator) Next(ctx context.Context, n int) ([]np.Stat, error) {
+	if n <= 0 {
+		n = 1
+	}
+
+	names, err := it.f.Readdirnames(n)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, mapErr(err)
+	}
+
+	sts := make([]np.Stat, 0, len(names))
+	for _, name := range names {
+		cn := node{u: it.d.u, rel: it.d.child(name)}
+		st, serr := cn.Stat()
+
+		// files that were removed since they were listed, and symlinks
+		// that are broken or point outside the root are not listed
+		var ne np.Error
+		if errors.As(serr, &ne) && (ne == np.ErrNotFound || ne == np.ErrPerm) {
+			continue
+		} else if serr != nil {
+			return nil, serr
+		}
+
+		sts = append(sts, st)
+	}
+
+	return sts, err //nolint:wrapcheck // io.EOF
+}
+
+func (it *dirIterator) Close() error {
+	return it.f.Close() //nolint:wrapcheck
+}
+
+// hostStat converts the FileInfo of a host file.
+//
+// Qid paths are derived from the device and inode numbers of the file. The
+// Qid version is left at 0, it's tracked by the server.
+func hostStat(fi fs.FileInfo) np.Stat {
+	st := iofs.Stat(fi)
+
+	if sys, ok := fi.Sys().(*syscall.Stat_t); ok {
+		dev := uint64(sys.Dev) //nolint:unconvert // not uint64 on every system
+		st.Dev = uint32(dev)
+		st.Qid.Path = uint64(sys.Ino) ^ dev<<48 //nolint:unconvert
+		st.Atime = atime(sys)
+	}
+
+	return st
+}
+
+// atime returns the access time in sys.
+func atime(sys *syscall.Stat_t) time.Time {
+	ts := statAtime(sys)
+	return time.Unix(int64(ts.Sec), int64(ts.Nsec)) //nolint:unconvert
+}
+
+// openFlag returns the os.OpenFile flag for mode.
+func openFlag(mode np.OpenMode) int {
+	var flag int
+	switch mode & 3 {
+	case np.ORead, np.OExec:
+		flag = os.O_RDONLY
+	case np.OWrite:
+		flag = os.O_WRONLY
+	case np.ORdwr:
+		flag = os.O_RDWR
+	}
+
+	if mode&np.OTrunc != 0 {
+		flag |= os.O_TRUNC
+	}
+
+	return flag
+}
+
+// splitPath splits the slash separated path rel into its directory and name.
+func splitPath(rel string) (string, string) {
+	i := strings.LastIndexByte(rel, '/')
+	if i < 0 {
+		return "", rel
+	}
+	return rel[:i], rel[i+1:]
+}
+
+// joinPath returns the rel path of name in the directory rel.
+func joinPath(rel, name string) string {
+	if rel == "" {
+		return name
+	}
+	return rel + "/" + name
+}
+
+// validName returns true if name is a single path element.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
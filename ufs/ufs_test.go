@@ -0,0 +1,192 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package ufs_test
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/noonien/np"
+	"github.com/noonien/np/client"
+	"github.com/noonien/np/nptest"
+	"github.com/noonien/np/ufs"
+	"github.com/stretchr/testify/require"
+)
+
+// serve serves the directory root/root, next to the directory root/outside
+// which has the file secret. Files and directories can be used by anyone, the
+// client isn't the owner.
+func serve(t *testing.T) (*nptest.Conn, string) {
+	t.Helper()
+
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	outside := filepath.Join(base, "outside")
+	for _, d := range []string{root, filepath.Join(root, "a"), filepath.Join(root, "b"), outside} {
+		require.Nil(t, os.MkdirAll(d, 0o777))
+		require.Nil(t, os.Chmod(d, 0o777))
+	}
+	for _, f := range []string{filepath.Join(root, "a", "f"), filepath.Join(outside, "secret")} {
+		require.Nil(t, os.WriteFile(f, []byte(filepath.Base(f)), 0o666))
+		require.Nil(t, os.Chmod(f, 0o666))
+	}
+
+	node, err := ufs.New(root)
+	require.Nil(t, err)
+	return nptest.ServePipe(t, node), root
+}
+
+func TestDotDot(t *testing.T) {
+	t.Parallel()
+
+	c, _ := serve(t)
+	ctx := context.Background()
+
+	// walking ".." stops at the root
+	f, err := c.Root.Walk(ctx, "..", "..", "a", "..", "..")
+	require.Nil(t, err)
+	require.Equal(t, c.Root.Qid(), f.Qid())
+
+	_, err = c.Root.Walk(ctx, "..", "outside")
+	require.ErrorIs(t, err, np.ErrNotFound)
+}
+
+func TestSymlinks(t *testing.T) {
+	t.Parallel()
+
+	c, root := serve(t)
+	secret := filepath.Join(filepath.Dir(root), "outside", "secret")
+	require.Nil(t, os.Symlink(secret, filepath.Join(root, "abs")))
+	require.Nil(t, os.Symlink("../outside/secret", filepath.Join(root, "rel")))
+	require.Nil(t, os.Symlink("../outside", filepath.Join(root, "reldir")))
+	require.Nil(t, os.Symlink("a/f", filepath.Join(root, "in")))
+
+	// links within the root can be followed
+	b, err := fs.ReadFile(c, "in")
+	require.Nil(t, err)
+	require.Equal(t, "f", string(b))
+
+	// links outside of it can't
+	for _, name := range []string{"abs", "rel", "reldir/secret"} {
+		_, err = fs.ReadFile(c, name)
+		require.ErrorIs(t, err, np.ErrPerm, name)
+	}
+
+	// and they aren't listed
+	ents, err := fs.ReadDir(c, ".")
+	require.Nil(t, err)
+	var names []string
+	for _, e := range ents {
+		names = append(names, e.Name())
+	}
+	require.Equal(t, []string{"a", "b", "in"}, names)
+}
+
+func TestWstat(t *testing.T) {
+	t.Parallel()
+
+	c, root := serve(t)
+	ctx := context.Background()
+	require.Nil(t, os.WriteFile(filepath.Join(root, "a", "g"), []byte("g"), 0o666))
+
+	f, err := c.Root.Walk(ctx, "a", "f")
+	require.Nil(t, err)
+
+	// a name that is taken fails the wstat before anything is changed
+	st := client.DontTouch()
+	st.Name = "g"
+	st.Length = 0
+	require.ErrorIs(t, f.Wstat(ctx, st), np.ErrExists)
+	b, err := os.ReadFile(filepath.Join(root, "a", "f"))
+	require.Nil(t, err)
+	require.Equal(t, "f", string(b))
+
+	st.Name = "h"
+	require.Nil(t, f.Wstat(ctx, st))
+	fi, err := os.Stat(filepath.Join(root, "a", "h"))
+	require.Nil(t, err)
+	require.Zero(t, fi.Size())
+	_, err = os.Stat(filepath.Join(root, "a", "f"))
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestRename(t *testing.T) {
+	t.Parallel()
+
+	_, root := serve(t)
+	ctx := context.Background()
+
+	node, err := ufs.New(root)
+	require.Nil(t, err)
+	d, ok := np.UnwrapValue[np.Dir](node)
+	require.True(t, ok)
+	a, err := np.WalkDir(ctx, d, "a")
+	require.Nil(t, err)
+	b, err := np.WalkDir(ctx, d, "b")
+	require.Nil(t, err)
+
+	r, ok := np.UnwrapValue[np.Renamer](a)
+	require.True(t, ok)
+	require.Nil(t, r.Rename("f", b, "g"))
+
+	data, err := os.ReadFile(filepath.Join(root, "b", "g"))
+	require.Nil(t, err)
+	require.Equal(t, "f", string(data))
+	_, err = os.Stat(filepath.Join(root, "a", "f"))
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	// files can't be moved out of the root, or to other trees
+	require.ErrorIs(t, r.Rename("..", b, "x"), np.ErrIllegalName)
+	require.ErrorIs(t, r.Rename("f", ufsNode(t), "x"), np.ErrCrossDevice)
+}
+
+// ufsNode returns the root of another tree.
+func ufsNode(t *testing.T) np.Node { //nolint:ireturn
+	t.Helper()
+
+	node, err := ufs.New(t.TempDir())
+	require.Nil(t, err)
+	return node
+}
+
+func TestQidVersions(t *testing.T) {
+	t.Parallel()
+
+	c, _ := serve(t)
+	ctx := context.Background()
+
+	version := func(name string) uint32 {
+		t.Helper()
+		st, err := fs.Stat(c, name)
+		require.Nil(t, err)
+		return st.Sys().(*np.Stat).Qid.Version //nolint:forcetypeassert
+	}
+
+	// writes and wstats change the file
+	f := version("a/f")
+	fid, err := c.Root.Walk(ctx, "a", "f")
+	require.Nil(t, err)
+	require.Nil(t, fid.Open(ctx, np.OWrite))
+	_, err = fid.Write(ctx, []byte("x"), 0)
+	require.Nil(t, err)
+	require.Nil(t, fid.Clunk(ctx))
+	require.Greater(t, version("a/f"), f)
+
+	f = version("a/f")
+	st := client.DontTouch()
+	st.Length = 0
+	require.Nil(t, c.Wstat("a/f", st))
+	require.Greater(t, version("a/f"), f)
+
+	// creates and removes change the directory
+	d := version("a")
+	require.Nil(t, c.Create("a/g", 0o644, nil))
+	require.Greater(t, version("a"), d)
+
+	d = version("a")
+	require.Nil(t, c.Remove("a/g"))
+	require.Greater(t, version("a"), d)
+}
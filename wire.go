@@ -0,0 +1,260 @@
+package np
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"go.rbn.im/neinp/message"
+)
+
+// fcall is a 9P message.
+//
+// Content is either a message.Content, for messages that are handled by
+// neinp, or a wireMsg for dialect specific messages.
+type fcall struct {
+	Tag     uint16
+	Content any
+}
+
+// wireMsg is implemented by messages that neinp does not know how to encode.
+type wireMsg interface {
+	msgType() uint8
+	encode(b *wbuf)
+	decode(b *rbuf)
+}
+
+// headerSize is the size of the size[4] type[1] tag[2] message header.
+const headerSize = 4 + 1 + 2
+
+// ioHeaderSize is the size of the header of Rread and Twrite messages,
+// IOHDRSZ in Plan 9.
+const ioHeaderSize = 24
+
+// unknownMsg is a message with a type that is not handled by the server.
+type unknownMsg struct {
+	typ uint8
+}
+
+func (m *unknownMsg) msgType() uint8 { return m.typ }
+func (m *unknownMsg) encode(b *wbuf) {}
+func (m *unknownMsg) decode(b *rbuf) {}
+
+// readMsg reads and decodes a single message from r.
+func (s *server) readMsg(r io.Reader) (fcall, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return fcall{}, err //nolint:wrapcheck
+	}
+
+	size := binary.LittleEndian.Uint32(hdr[:])
+	if size < headerSize {
+		return fcall{}, ErrBadMessage
+	}
+	if size > s.msize() {
+		return fcall{}, ErrMessageTooLong
+	}
+
+	buf := make([]byte, size)
+	copy(buf, hdr[:])
+	if _, err := io.ReadFull(r, buf[len(hdr):]); err != nil {
+		return fcall{}, err //nolint:wrapcheck
+	}
+
+	typ := buf[4]
+	tag := binary.LittleEndian.Uint16(buf[5:])
+
+	if m := s.newWireMsg(typ); m != nil {
+		rb := &rbuf{b: buf[headerSize:]}
+		m.decode(rb)
+		if rb.err != nil {
+			return fcall{}, fmt.Errorf("decode %T: %w", m, rb.err)
+		}
+		return fcall{Tag: tag, Content: m}, nil
+	}
+
+	var m message.Message
+	if _, err := m.Decode(bytes.NewReader(buf)); err != nil {
+		return fcall{}, err //nolint:wrapcheck
+	}
+	return fcall{Tag: tag, Content: m.Content}, nil
+}
+
+// newWireMsg returns an empty message for typ, if it's not a message that
+// should be decoded by neinp.
+func (s *server) newWireMsg(typ uint8) wireMsg { //nolint:ireturn
+	switch s.dialect() {
+	case dialectU:
+		if m := newUMsg(typ); m != nil {
+			return m
+		}
+	case dialectL:
+		if m := newLMsg(typ); m != nil {
+			return m
+		}
+	case dialect9P:
+	}
+
+	if typ < msgTversion || typ > msgRwstat || typ == msgTerror {
+		return &unknownMsg{typ: typ}
+	}
+	return nil
+}
+
+// writeMsg encodes f and writes it to w.
+func writeMsg(w io.Writer, f fcall) error {
+	switch c := f.Content.(type) {
+	case wireMsg:
+		b := newWbuf(c.msgType(), f.Tag)
+		c.encode(b)
+		_, err := w.Write(b.bytes())
+		return err //nolint:wrapcheck
+
+	case message.Content:
+		m := message.Message{Tag: f.Tag, Content: c}
+		_, err := m.Encode(w)
+		return err //nolint:wrapcheck
+	}
+
+	return fmt.Errorf("%w: %T", ErrUnexpectedMessageType, f.Content)
+}
+
+// 9P2000 message types.
+const (
+	msgTversion = 100
+	msgTerror   = 106
+	msgRwstat   = 127
+)
+
+// rbuf decodes little-endian 9P data types.
+//
+// Once an error is encountered, all following reads return zero values.
+type rbuf struct {
+	b   []byte
+	err error
+}
+
+func (r *rbuf) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.b) < n {
+		r.err = ErrBadMessage
+		return nil
+	}
+	p := r.b[:n]
+	r.b = r.b[n:]
+	return p
+}
+
+func (r *rbuf) len() int { return len(r.b) }
+
+func (r *rbuf) u8() uint8 {
+	if p := r.next(1); p != nil {
+		return p[0]
+	}
+	return 0
+}
+
+func (r *rbuf) u16() uint16 {
+	if p := r.next(2); p != nil {
+		return binary.LittleEndian.Uint16(p)
+	}
+	return 0
+}
+
+func (r *rbuf) u32() uint32 {
+	if p := r.next(4); p != nil {
+		return binary.LittleEndian.Uint32(p)
+	}
+	return 0
+}
+
+func (r *rbuf) u64() uint64 {
+	if p := r.next(8); p != nil {
+		return binary.LittleEndian.Uint64(p)
+	}
+	return 0
+}
+
+func (r *rbuf) str() string {
+	return string(r.next(int(r.u16())))
+}
+
+func (r *rbuf) qid() Qid {
+	return Qid{
+		Type:    QidType(r.u8()),
+		Version: r.u32(),
+		Path:    r.u64(),
+	}
+}
+
+// time decodes a sec[8] nsec[8] timestamp.
+func (r *rbuf) time() time.Time {
+	sec, nsec := r.u64(), r.u64()
+	return time.Unix(int64(sec), int64(nsec))
+}
+
+// wbuf encodes little-endian 9P data types.
+type wbuf struct {
+	b []byte
+}
+
+// newWbuf returns a wbuf that starts with a message header.
+//
+// The size of the message is set by bytes.
+func newWbuf(typ uint8, tag uint16) *wbuf {
+	b := &wbuf{b: make([]byte, 0, 64)}
+	b.u32(0)
+	b.u8(typ)
+	b.u16(tag)
+	return b
+}
+
+func (w *wbuf) bytes() []byte {
+	binary.LittleEndian.PutUint32(w.b, uint32(len(w.b)))
+	return w.b
+}
+
+func (w *wbuf) u8(v uint8) { w.b = append(w.b, v) }
+
+func (w *wbuf) u16(v uint16) {
+	w.b = append(w.b, byte(v), byte(v>>8))
+}
+
+func (w *wbuf) u32(v uint32) {
+	w.b = append(w.b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func (w *wbuf) u64(v uint64) {
+	w.u32(uint32(v))
+	w.u32(uint32(v >> 32))
+}
+
+func (w *wbuf) str(s string) {
+	w.u16(uint16(len(s)))
+	w.b = append(w.b, s...)
+}
+
+func (w *wbuf) data(p []byte) {
+	w.b = append(w.b, p...)
+}
+
+func (w *wbuf) qid(q Qid) {
+	w.u8(uint8(q.Type))
+	w.u32(q.Version)
+	w.u64(q.Path)
+}
+
+// time encodes t as sec[8] nsec[8].
+func (w *wbuf) time(t time.Time) {
+	if t.IsZero() {
+		w.u64(0)
+		w.u64(0)
+		return
+	}
+	w.u64(uint64(t.Unix()))
+	w.u64(uint64(t.Nanosecond()))
+}
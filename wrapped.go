@@ -1,5 +1,7 @@
 package np
 
+import "context"
+
 // Unwrapper, when impleemnted by a Node, will have Unwrap() called on it before
 // checking if it implements any interfaces the server requires (i.e Open, io.ReaderAt, etc).
 type Unwrapper interface {
@@ -17,6 +19,11 @@ type Wrapped struct {
 
 func (w *Wrapped) Unwrap() any { return w.Val }
 
+// StatContext returns the Stat of the Node, Val is not asked for it.
+func (w *Wrapped) StatContext(ctx context.Context) (Stat, error) {
+	return StatNode(ctx, w.Node)
+}
+
 // UnwrapValue is used on values to unwrap them until the requested type is found
 // or until there is nothing else to unwrap (in which case, the second return value will be false).
 func UnwrapValue[T any](v any) (T, bool) { //nolint:ireturn // returning an interface is the point of this function